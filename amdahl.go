@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/optimize"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// fitAmdahl finds the model with no crosstalk, kappa = 0, that best fits the
+// measurements, using least squares with each squared residual multiplied
+// by the measurement's weight, or by 1 if weights is nil. That's Amdahl's
+// law: throughput rises towards lambda/sigma but never falls.
+func fitAmdahl(concurrency, throughput, weights []float64) (uslModel, error) {
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+
+	f := func(x []float64) float64 {
+		sigma, lambda := math.Exp(x[0]), math.Exp(x[1])
+		var mismatch float64
+		for i, N := range concurrency {
+			pred := concurrencyToThroughput(N, sigma, 0, lambda)
+			mismatch += weight(i) * (pred - throughput[i]) * (pred - throughput[i])
+		}
+		return mismatch
+	}
+
+	grad := func(grad, x []float64) {
+		grad[0], grad[1] = 0, 0
+		sigma, lambda := math.Exp(x[0]), math.Exp(x[1])
+		for i, N := range concurrency {
+			pred := concurrencyToThroughput(N, sigma, 0, lambda)
+			dMismatchDPred := 2 * weight(i) * (pred - throughput[i])
+			dPredDSigma, _, dPredDLambda := concurrencyToThroughputDeriv(N, sigma, 0, lambda)
+			grad[0] += dMismatchDPred * dPredDSigma * sigma
+			grad[1] += dMismatchDPred * dPredDLambda * lambda
+		}
+	}
+
+	result, err := optimize.Local(optimize.Problem{Func: f, Grad: grad}, []float64{0, -3}, nil, nil)
+	if result == nil {
+		return uslModel{}, err
+	}
+	return uslModel{Sigma: math.Exp(result.X[0]), Lambda: math.Exp(result.X[1])}, err
+}
+
+// modelComparison compares the fit of the USL with that of Amdahl's law.
+// When crosstalk is negligible, Amdahl's law, with one coefficient fewer,
+// gives tighter and more stable estimates.
+type modelComparison struct {
+	// The coefficients of Amdahl's law.
+	Sigma  float64 `json:"sigma"`
+	Lambda float64 `json:"lambda"`
+	// The Akaike information criterion of each fit; the lower, the better.
+	USLAIC    jsonFloat `json:"uslAIC"`
+	AmdahlAIC jsonFloat `json:"amdahlAIC"`
+	// The F statistic of the improvement kappa makes to the fit, and the
+	// probability of one at least as large were kappa really 0.
+	F jsonFloat `json:"f"`
+	P jsonFloat `json:"p"`
+	// Whichever of "usl" and "amdahl" has the lower AIC.
+	Better string `json:"better"`
+}
+
+// Fits Amdahl's law to the measurements and compares it with usl, which was
+// fitted to them with the given weights, which may be nil, and with the
+// Huber loss if robust is set. Amdahl's law is fitted the same way, and
+// both fits are judged by that loss. It returns nil if there are too few
+// measurements to tell the two apart, as the F-test needs more than there
+// are coefficients.
+func compareAmdahl(concurrency, throughput, weights []float64, robust bool, usl uslModel) *modelComparison {
+	n := float64(len(concurrency))
+	if n <= 3 {
+		return nil
+	}
+	fit := fitFunc(fitAmdahl)
+	if robust {
+		fit = func(concurrency, throughput, weights []float64) (uslModel, error) {
+			return fitRobust(fitAmdahl, concurrency, throughput, weights)
+		}
+	}
+	amdahl, err := fit(concurrency, throughput, weights)
+	if err != nil {
+		debugf("Amdahl's law optimization error: %s", err)
+	}
+
+	residuals := func(m uslModel) []float64 {
+		r := make([]float64, len(concurrency))
+		for i, N := range concurrency {
+			r[i] = throughput[i] - m.throughputAt(N)
+		}
+		return r
+	}
+	// Residuals beyond huberK times the scale of the USL's count linearly
+	// rather than squared, as in its fit, with the same scale for both
+	// models so that they're judged alike.
+	huber := math.Inf(1)
+	if robust {
+		if scale := residualScale(residuals(usl)); scale > 0 {
+			huber = huberK * scale
+		}
+	}
+	// The weighted sum of the loss of each residual, twice the Huber loss
+	// so that it's the sum of squares for small residuals.
+	loss := func(m uslModel) float64 {
+		var sum float64
+		for i, r := range residuals(m) {
+			r = math.Abs(r)
+			l := r * r
+			if r > huber {
+				l = huber * (2*r - huber)
+			}
+			if weights != nil {
+				l *= weights[i]
+			}
+			sum += l
+		}
+		return sum
+	}
+	// The least squares form of the AIC, up to a constant.
+	aic := func(ssr, coefficients float64) float64 {
+		return n*math.Log(ssr/n) + 2*coefficients
+	}
+	uslSSR, amdahlSSR := loss(usl), loss(amdahl)
+
+	c := &modelComparison{
+		Sigma:     amdahl.Sigma,
+		Lambda:    amdahl.Lambda,
+		USLAIC:    jsonFloat(aic(uslSSR, 3)),
+		AmdahlAIC: jsonFloat(aic(amdahlSSR, 2)),
+		F:         jsonFloat((amdahlSSR - uslSSR) / (uslSSR / (n - 3))),
+		Better:    "usl",
+	}
+	c.P = jsonFloat(distuv.F{D1: 1, D2: n - 3}.Survival(math.Max(float64(c.F), 0)))
+	if c.AmdahlAIC <= c.USLAIC {
+		c.Better = "amdahl"
+	}
+	return c
+}
+
+// Prints Amdahl's law's coefficients and how its fit compares with the
+// USL's.
+func printComparison(w io.Writer, c *modelComparison) {
+	better := "the USL"
+	if c.Better == "amdahl" {
+		better = "Amdahl's law"
+	}
+	fmt.Fprintf(w, "Amdahl's law (kappa=0): sigma %g, lambda %g\n", c.Sigma, c.Lambda)
+	fmt.Fprintf(w, "AIC: USL %.2f, Amdahl's law %.2f; F-test p=%.3g: %s fits better\n", c.USLAIC, c.AmdahlAIC, c.P, better)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompareAmdahl(t *testing.T) {
+	levels := []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48}
+	for _, tc := range []struct {
+		name    string
+		truth   uslModel
+		factors map[int]float64
+		weights []float64
+		robust  bool
+		want    string
+	}{
+		{name: "crosstalk", truth: uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}, want: "usl"},
+		{name: "no crosstalk", truth: uslModel{Sigma: 0.05, Lambda: 800}, want: "amdahl"},
+		{name: "weighted", truth: uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}, weights: []float64{1, 1, 1, 1, 1, 2, 2, 2, 2, 2}, want: "usl"},
+		{name: "robust", truth: uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}, factors: map[int]float64{5: 0.5}, robust: true, want: "usl"},
+	} {
+		throughput := measure(tc.truth, levels, tc.factors)
+		fit := fitFunc(fitWeightedUSL)
+		if tc.robust {
+			fit = fitRobustUSL
+		}
+		usl, _ := fit(levels, throughput, tc.weights)
+		c := compareAmdahl(levels, throughput, tc.weights, tc.robust, usl)
+		if c == nil {
+			t.Fatalf("%s: got no comparison", tc.name)
+		}
+		if c.Better != tc.want {
+			t.Errorf("%s: got %s better (AIC USL %.2f, Amdahl's law %.2f), want %s", tc.name, c.Better, c.USLAIC, c.AmdahlAIC, tc.want)
+		}
+		if math.IsNaN(float64(c.F)) || c.F < -1e-6 {
+			t.Errorf("%s: got F %g, want it at least 0, as the USL can always fit at least as well", tc.name, c.F)
+		}
+	}
+}
+
+// A measurement with next to no weight should make next to no difference to
+// either fit or to how they compare, as it makes none to the USL's.
+func TestCompareAmdahlWeights(t *testing.T) {
+	levels := []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48}
+	truth := uslModel{Sigma: 0.05, Lambda: 800}
+	weights := []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1e-9}
+	compare := func(throughput, weights []float64) *modelComparison {
+		usl, _ := fitWeightedUSL(levels, throughput, weights)
+		return compareAmdahl(levels, throughput, weights, false, usl)
+	}
+
+	want := compare(measure(truth, levels, nil), weights)
+	// The throughput at 48 workers falls, as if there were crosstalk.
+	dipped := measure(truth, levels, map[int]float64{9: 0.6})
+	got := compare(dipped, weights)
+	if !near(got.Sigma, want.Sigma, 1e-6) || !near(got.Lambda, want.Lambda, 1e-6) || !near(float64(got.F), float64(want.F), 1e-3) {
+		t.Errorf("with the dip weighted out, got %+v, want %+v", got, want)
+	}
+	if unweighted := compare(dipped, nil); !(unweighted.F > 5*want.F) {
+		t.Errorf("with the dip weighted in, got F %g, want it far above %g", unweighted.F, want.F)
+	}
+}
+
+func TestCompareAmdahlTooFewMeasurements(t *testing.T) {
+	levels := []float64{1, 2, 4}
+	throughput := []float64{100, 190, 340}
+	if c := compareAmdahl(levels, throughput, nil, false, uslModel{Sigma: 0.05, Kappa: 0.001, Lambda: 100}); c != nil {
+		t.Errorf("got %+v, want nil", c)
+	}
+}
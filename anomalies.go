@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// A level is anomalous if its throughput is more than anomalousDip below
+// that of both of the levels either side of it, or if its throughput from
+// one second to the next has a coefficient of variation above
+// maxPerSecondVariation.
+const (
+	anomalousDip          = 0.1
+	maxPerSecondVariation = 0.25
+)
+
+// Returns the coefficient of variation of the requests that succeeded in
+// each whole second of the level, or 0 if it lasted less than two.
+func perSecondVariation(r levelResult) float64 {
+	xs := make([]float64, 0, len(r.perSecond))
+	for i, n := range r.perSecond {
+		if time.Duration(i+1)*time.Second > r.duration {
+			break
+		}
+		xs = append(xs, float64(n))
+	}
+	if len(xs) < 2 {
+		return 0
+	}
+	mean, stddev := stat.MeanStdDev(xs, nil)
+	if mean == 0 {
+		return 0
+	}
+	return stddev / mean
+}
+
+// Returns why r is anomalous, given the throughput of the levels either
+// side of it, or "" if it isn't. Either neighbour may be nil.
+func anomaly(r levelResult, prev, next *levelResult) string {
+	if prev != nil && next != nil {
+		lower := prev.throughput
+		if next.throughput < lower {
+			lower = next.throughput
+		}
+		if r.throughput < lower*(1-anomalousDip) {
+			return fmt.Sprintf("throughput %.1f is well below that at concurrency %d and %d", r.throughput, prev.concurrency, next.concurrency)
+		}
+	}
+	if v := perSecondVariation(r); v > maxPerSecondVariation {
+		return fmt.Sprintf("throughput varied by %.0f%% from second to second", 100*v)
+	}
+	return ""
+}
+
+// Runs each anomalous level again once with rerun, keeping the new result
+// unless it's anomalous too and varied more from second to second than the
+// first.
+func rerunAnomalous(results []levelResult, rerun func(level int) levelResult) {
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return results[order[i]].concurrency < results[order[j]].concurrency })
+
+	// Neighbours are judged by the results as first measured.
+	first := append([]levelResult(nil), results...)
+	for j, i := range order {
+		var prev, next *levelResult
+		if j > 0 {
+			prev = &first[order[j-1]]
+		}
+		if j+1 < len(order) {
+			next = &first[order[j+1]]
+		}
+		reason := anomaly(first[i], prev, next)
+		if reason == "" {
+			continue
+		}
+
+		level := first[i].concurrency
+		infof("concurrency %d: %s, so running it again", level, reason)
+		r := rerun(level)
+		r.replicate = first[i].replicate
+		if anomaly(r, prev, next) == "" || perSecondVariation(r) <= perSecondVariation(first[i]) {
+			results[i] = r
+		} else {
+			infof("concurrency %d: keeping the first run, which was steadier", level)
+		}
+	}
+}
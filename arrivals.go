@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// arrivalSchedule hands out the times at which requests are to be sent in
+// open-loop mode, with -rps: at a fixed rate from the start of a level until
+// its end, whether or not earlier requests have completed. Workers share a
+// schedule, each taking the next time once it's free to send.
+type arrivalSchedule struct {
+	mu       sync.Mutex
+	next     time.Time
+	end      time.Time
+	length   time.Duration
+	interval time.Duration
+	// If set, the times between arrivals are drawn from an exponential
+	// distribution with a mean of interval, as for a Poisson process, rather
+	// than all being interval.
+	poisson *rand.Rand
+}
+
+// Makes a schedule of arrivals at rate per second for length. If poisson
+// isn't nil, the arrivals are a Poisson process drawn using it.
+func newArrivalSchedule(rate float64, length time.Duration, poisson *rand.Rand) *arrivalSchedule {
+	return &arrivalSchedule{
+		length:   length,
+		interval: time.Duration(float64(time.Second) / rate),
+		poisson:  poisson,
+	}
+}
+
+// Starts the schedule, which must be done before any times are taken.
+func (a *arrivalSchedule) begin(start time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next, a.end = start, start.Add(a.length)
+}
+
+// Returns the time the next request is to be sent at, or false if the
+// schedule has ended.
+func (a *arrivalSchedule) take() (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.next.Before(a.end) {
+		return time.Time{}, false
+	}
+	at := a.next
+	if a.poisson != nil {
+		a.next = a.next.Add(time.Duration(a.poisson.ExpFloat64() * float64(a.interval)))
+	} else {
+		a.next = a.next.Add(a.interval)
+	}
+	return at, true
+}
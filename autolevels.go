@@ -0,0 +1,61 @@
+package main
+
+import "sort"
+
+// The bounds on how -autoLevels searches for the knee: concurrency stops
+// doubling once throughput improves by less than minAutoLevelGain or it
+// reaches maxAutoLevel, and then up to autoLevelBisections more levels are
+// tested around the best so far.
+const (
+	minAutoLevelGain    = 0.05
+	maxAutoLevel        = 4096
+	autoLevelBisections = 4
+)
+
+// Chooses concurrency levels to test adaptively, calling measure to test
+// each and return its throughput. Starting at 1, concurrency doubles until
+// throughput stops improving, and then the gaps either side of the level
+// with the highest throughput are bisected, the wider first, to sample
+// more densely around the knee. Returns the levels tested, in order.
+func chooseLevels(measure func(level int) float64) []int {
+	throughput := make(map[int]float64)
+	var tested []int
+	test := func(level int) {
+		throughput[level] = measure(level)
+		tested = append(tested, level)
+	}
+
+	test(1)
+	for level := 2; level <= maxAutoLevel; level *= 2 {
+		prev := throughput[level/2]
+		test(level)
+		if throughput[level] < prev*(1+minAutoLevelGain) {
+			break
+		}
+	}
+
+	for i := 0; i < autoLevelBisections; i++ {
+		sorted := append([]int(nil), tested...)
+		sort.Ints(sorted)
+		best := 0
+		for j, level := range sorted {
+			if throughput[level] > throughput[sorted[best]] {
+				best = j
+			}
+		}
+
+		// The widest gap next to the best level that has room for another.
+		lo, hi := 0, 0
+		if best > 0 {
+			lo, hi = sorted[best-1], sorted[best]
+		}
+		if best+1 < len(sorted) && sorted[best+1]-sorted[best] > hi-lo {
+			lo, hi = sorted[best], sorted[best+1]
+		}
+		if hi-lo < 2 {
+			break
+		}
+		test((lo + hi) / 2)
+	}
+	return tested
+}
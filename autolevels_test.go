@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChooseLevels(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		truth uslModel
+		want  []int
+	}{
+		// Peaks at 20, so doubling stops at 32 and the gaps either side of
+		// 16 are bisected.
+		{"peak at 20", uslModel{Sigma: 0.05, Kappa: 0.95 / 400, Lambda: 100}, []int{1, 2, 4, 8, 16, 32, 24, 20, 18, 22}},
+		// Never scales, so doubling stops at 2.
+		{"serial", uslModel{Sigma: 0.99, Kappa: 0.0001, Lambda: 100}, []int{1, 2}},
+	} {
+		var measured []int
+		got := chooseLevels(func(level int) float64 {
+			measured = append(measured, level)
+			return tc.truth.throughputAt(float64(level))
+		})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+		if !reflect.DeepEqual(measured, got) {
+			t.Errorf("%s: measured %v but returned %v", tc.name, measured, got)
+		}
+	}
+}
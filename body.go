@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+)
+
+// Builds a multipart/form-data body from fields given as name=value, or as
+// name=@file to upload the contents of file. Returns the body along with the
+// Content-Type, which carries the boundary between parts.
+func buildForm(fields []string) ([]byte, string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i <= 0 {
+			return nil, "", fmt.Errorf("expected 'name=value' or 'name=@file', got '%s'", field)
+		}
+		name, value := field[:i], field[i+1:]
+		if !strings.HasPrefix(value, "@") {
+			if err := w.WriteField(name, value); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		filename := value[1:]
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, "", err
+		}
+		part, err := w.CreateFormFile(name, filepath.Base(filename))
+		if err != nil {
+			return nil, "", err
+		}
+		part.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), w.FormDataContentType(), nil
+}
+
+// Returns body compressed with gzip.
+func gzipBody(body []byte) []byte {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(body)
+	w.Close()
+	return compressed.Bytes()
+}
+
+// chunkReader reads body at most size bytes at a time, so that a request
+// sent with chunked transfer encoding has a chunk per read.
+type chunkReader struct {
+	body []byte
+	size int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.body) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > c.size {
+		p = p[:c.size]
+	}
+	n := copy(p, c.body)
+	c.body = c.body[n:]
+	return n, nil
+}
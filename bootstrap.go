@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"text/tabwriter"
+)
+
+// confidenceInterval is the range a quantity lies in with 95% confidence.
+type confidenceInterval struct {
+	Low  jsonFloat `json:"low"`
+	High jsonFloat `json:"high"`
+}
+
+// modelIntervals gives confidence intervals for the model's coefficients and
+// the maxima they imply.
+type modelIntervals struct {
+	Resamples      int                `json:"resamples"`
+	Sigma          confidenceInterval `json:"sigma"`
+	Kappa          confidenceInterval `json:"kappa"`
+	Lambda         confidenceInterval `json:"lambda"`
+	MaxConcurrency confidenceInterval `json:"maxConcurrency"`
+	MaxRps         confidenceInterval `json:"maxRps"`
+}
+
+// Returns the 2.5th and 97.5th percentiles of values, ignoring any that
+// aren't finite.
+func percentileInterval(values []float64) confidenceInterval {
+	var finite []float64
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			finite = append(finite, v)
+		}
+	}
+	if len(finite) == 0 {
+		return confidenceInterval{jsonFloat(math.NaN()), jsonFloat(math.NaN())}
+	}
+	sort.Float64s(finite)
+	at := func(p float64) jsonFloat {
+		return jsonFloat(finite[int(math.Round(p*float64(len(finite)-1)))])
+	}
+	return confidenceInterval{at(0.025), at(0.975)}
+}
+
+// Estimates confidence intervals for the model fitted to the measurements
+// by fitting it again with fit to resamples of them, each drawn with
+// replacement along with its weight. The more measurements there are, such
+// as with -replicates, the more meaningful the intervals.
+func bootstrapModel(concurrency, throughput, weights []float64, fit fitFunc, resamples int, rng *rand.Rand) modelIntervals {
+	var sigma, kappa, lambda, maxConcurrency, maxRps []float64
+	c := make([]float64, len(concurrency))
+	t := make([]float64, len(throughput))
+	var w []float64
+	if weights != nil {
+		w = make([]float64, len(weights))
+	}
+	for i := 0; i < resamples; i++ {
+		for j := range c {
+			k := rng.Intn(len(concurrency))
+			c[j], t[j] = concurrency[k], throughput[k]
+			if w != nil {
+				w[j] = weights[k]
+			}
+		}
+		// Optimization errors are common and the model is still usable, as
+		// with the fit to the measurements themselves.
+		m, _ := fit(c, t, w)
+		sigma = append(sigma, m.Sigma)
+		kappa = append(kappa, m.Kappa)
+		lambda = append(lambda, m.Lambda)
+		maxConcurrency = append(maxConcurrency, m.maxConcurrency())
+		maxRps = append(maxRps, m.maxRps())
+	}
+	return modelIntervals{
+		Resamples:      resamples,
+		Sigma:          percentileInterval(sigma),
+		Kappa:          percentileInterval(kappa),
+		Lambda:         percentileInterval(lambda),
+		MaxConcurrency: percentileInterval(maxConcurrency),
+		MaxRps:         percentileInterval(maxRps),
+	}
+}
+
+func printIntervals(out io.Writer, iv modelIntervals) {
+	fmt.Fprintf(out, "95%% confidence intervals from %d bootstrap resamples:\n", iv.Resamples)
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	for _, row := range []struct {
+		name string
+		ci   confidenceInterval
+	}{
+		{"sigma", iv.Sigma},
+		{"kappa", iv.Kappa},
+		{"lambda", iv.Lambda},
+		{"maxConcurrency", iv.MaxConcurrency},
+		{"maxRps", iv.MaxRps},
+	} {
+		fmt.Fprintf(w, "%s\t%.6g\t–\t%.6g\t\n", row.name, float64(row.ci.Low), float64(row.ci.High))
+	}
+	w.Flush()
+}
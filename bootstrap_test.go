@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBootstrapModel(t *testing.T) {
+	truth := uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}
+	var levels []float64
+	for _, N := range []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48} {
+		levels = append(levels, N, N, N)
+	}
+	for _, tc := range []struct {
+		name    string
+		factors map[int]float64
+		weights []float64
+	}{
+		{name: "unweighted"},
+		// The weights go with the measurements they belong to, so the wild
+		// one never pulls a resample's fit.
+		{name: "wild measurement weighted out", factors: map[int]float64{16: 0.3}, weights: weightsWithout(len(levels), 16)},
+	} {
+		throughput := measure(truth, levels, tc.factors)
+		iv := bootstrapModel(levels, throughput, tc.weights, fitWeightedUSL, 200, rand.New(rand.NewSource(1)))
+		if iv.Resamples != 200 {
+			t.Errorf("%s: got %d resamples, want 200", tc.name, iv.Resamples)
+		}
+		for _, c := range []struct {
+			name     string
+			interval confidenceInterval
+			truth    float64
+		}{
+			{"sigma", iv.Sigma, truth.Sigma},
+			{"kappa", iv.Kappa, truth.Kappa},
+			{"lambda", iv.Lambda, truth.Lambda},
+			{"maxConcurrency", iv.MaxConcurrency, truth.maxConcurrency()},
+			{"maxRps", iv.MaxRps, truth.maxRps()},
+		} {
+			if !(float64(c.interval.Low) <= c.truth && c.truth <= float64(c.interval.High)) {
+				t.Errorf("%s: %s interval [%g, %g] doesn't contain %g", tc.name, c.name, c.interval.Low, c.interval.High, c.truth)
+			}
+		}
+		if width := float64(iv.MaxRps.High-iv.MaxRps.Low) / truth.maxRps(); width > 0.05 {
+			t.Errorf("%s: maxRps interval [%.0f, %.0f] is %.1f%% of it wide, want it under 5%%", tc.name, iv.MaxRps.Low, iv.MaxRps.High, 100*width)
+		}
+	}
+}
+
+// Returns n weights of 1 but for a negligible one at i.
+func weightsWithout(n, i int) []float64 {
+	weights := make([]float64, n)
+	for j := range weights {
+		weights[j] = 1
+	}
+	weights[i] = 1e-9
+	return weights
+}
+
+func TestPercentileInterval(t *testing.T) {
+	var values []float64
+	for i := 0; i <= 1000; i++ {
+		values = append(values, float64(1000-i))
+	}
+	if got := percentileInterval(values); got != (confidenceInterval{25, 975}) {
+		t.Errorf("got %+v, want [25, 975]", got)
+	}
+}
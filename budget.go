@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The shortest time -totalDuration may leave each level to be measured for.
+const minLevelDuration = 100 * time.Millisecond
+
+// Divides total between levels, each run replicates times, as the time each
+// run is measured for. Time spent on warmup, on each run's rampup and on
+// the cooldowns between runs comes out of total first. Levels share the
+// rest equally or, if byConcurrency, in proportion to their concurrency, so
+// that higher levels, whose throughput varies more, run longer.
+func splitDuration(total time.Duration, levels []int, replicates int, warmup, rampup, cooldown time.Duration, byConcurrency bool) (map[int]time.Duration, error) {
+	runs := len(levels) * replicates
+	measured := total - warmup - time.Duration(runs)*rampup - time.Duration(runs-1)*cooldown
+
+	var weights float64
+	for _, level := range levels {
+		if byConcurrency {
+			weights += float64(level)
+		} else {
+			weights++
+		}
+	}
+
+	durations := make(map[int]time.Duration, len(levels))
+	for _, level := range levels {
+		weight := 1.0
+		if byConcurrency {
+			weight = float64(level)
+		}
+		d := time.Duration(float64(measured) * weight / weights / float64(replicates)).Truncate(time.Millisecond)
+		if d < minLevelDuration {
+			return nil, fmt.Errorf("%s leaves concurrency %d only %s, and each level needs at least %s", total, level, d, minLevelDuration)
+		}
+		durations[level] = d
+	}
+	return durations, nil
+}
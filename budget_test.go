@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitDuration(t *testing.T) {
+	for _, tc := range []struct {
+		name                     string
+		total                    time.Duration
+		levels                   []int
+		replicates               int
+		warmup, rampup, cooldown time.Duration
+		byConcurrency            bool
+		want                     map[int]time.Duration
+	}{
+		{
+			name: "equal", total: 10 * time.Second, levels: []int{1, 2, 4, 8, 16}, replicates: 1,
+			want: map[int]time.Duration{1: 2 * time.Second, 2: 2 * time.Second, 4: 2 * time.Second, 8: 2 * time.Second, 16: 2 * time.Second},
+		},
+		{
+			name: "replicates", total: 12 * time.Second, levels: []int{1, 2}, replicates: 3,
+			want: map[int]time.Duration{1: 2 * time.Second, 2: 2 * time.Second},
+		},
+		{
+			// 20s less 2s of warmup, 4 rampups of 1s and 3 cooldowns of
+			// 2s leaves 8s.
+			name: "overheads", total: 20 * time.Second, levels: []int{1, 2, 3, 4}, replicates: 1,
+			warmup: 2 * time.Second, rampup: time.Second, cooldown: 2 * time.Second,
+			want: map[int]time.Duration{1: 2 * time.Second, 2: 2 * time.Second, 3: 2 * time.Second, 4: 2 * time.Second},
+		},
+		{
+			name: "by concurrency", total: 10 * time.Second, levels: []int{1, 4, 5}, replicates: 1, byConcurrency: true,
+			want: map[int]time.Duration{1: time.Second, 4: 4 * time.Second, 5: 5 * time.Second},
+		},
+		{
+			name: "truncated to milliseconds", total: time.Second, levels: []int{1, 2, 3}, replicates: 1,
+			want: map[int]time.Duration{1: 333 * time.Millisecond, 2: 333 * time.Millisecond, 3: 333 * time.Millisecond},
+		},
+		{name: "too short", total: time.Second, levels: []int{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}, replicates: 1},
+		{name: "overheads too long", total: 5 * time.Second, levels: []int{1, 2}, replicates: 1, warmup: 5 * time.Second},
+		{name: "lowest too short by concurrency", total: 10 * time.Second, levels: []int{1, 100}, replicates: 1, byConcurrency: true},
+	} {
+		got, err := splitDuration(tc.total, tc.levels, tc.replicates, tc.warmup, tc.rampup, tc.cooldown, tc.byConcurrency)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for level, d := range tc.want {
+			if got[level] != d {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
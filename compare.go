@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"text/tabwriter"
+)
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*f = jsonFloat(math.NaN())
+		return nil
+	}
+	return json.Unmarshal(b, (*float64)(f))
+}
+
+// Reads a report saved with -out or written by -outputFormat json.
+func readRunReport(filename string) (runReport, error) {
+	var r runReport
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(b, &r)
+	return r, err
+}
+
+// Returns the relative change from baseline to current.
+func relativeChange(baseline, current float64) float64 {
+	return (current - baseline) / baseline
+}
+
+// Writes the change in the fitted model and in the throughput at each
+// concurrency level both runs measured, from baseline to current. Returns
+// whether maxRps or the throughput at any level fell by more than
+// tolerance, a fraction of the baseline.
+func compareReports(w io.Writer, baseline, current runReport, tolerance float64) (regressed bool) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tbaseline\tcurrent\tchange\t")
+	row := func(name string, baseline, current float64, format string, isThroughput bool) {
+		change := relativeChange(baseline, current)
+		fmt.Fprintf(tw, "%s\t"+format+"\t"+format+"\t%+.1f%%\t", name, baseline, current, 100*change)
+		if isThroughput && change < -tolerance {
+			fmt.Fprint(tw, "  regressed")
+			regressed = true
+		}
+		fmt.Fprintln(tw)
+	}
+	row("maxRps", float64(baseline.MaxRps), float64(current.MaxRps), "%.0f", true)
+	row("maxConcurrency", float64(baseline.MaxConcurrency), float64(current.MaxConcurrency), "%.0f", false)
+	row("sigma", float64(baseline.Sigma), float64(current.Sigma), "%.6g", false)
+	row("kappa", float64(baseline.Kappa), float64(current.Kappa), "%.6g", false)
+	row("lambda", float64(baseline.Lambda), float64(current.Lambda), "%.0f", false)
+
+	byConcurrency := make(map[int]levelReport, len(baseline.Levels))
+	for _, level := range baseline.Levels {
+		byConcurrency[level.Concurrency] = level
+	}
+	for _, level := range current.Levels {
+		if b, ok := byConcurrency[level.Concurrency]; ok {
+			name := fmt.Sprintf("throughput at %d", level.Concurrency)
+			row(name, b.Throughput, level.Throughput, "%.0f", true)
+		}
+	}
+	tw.Flush()
+	return regressed
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareReports(t *testing.T) {
+	report := func(maxRps float64, throughput ...float64) runReport {
+		r := runReport{modelReport: modelReport{MaxRps: jsonFloat(maxRps)}}
+		for i, x := range throughput {
+			r.Levels = append(r.Levels, levelReport{Concurrency: 1 << uint(i), Throughput: x})
+		}
+		return r
+	}
+	baseline := report(1000, 100, 200, 400)
+	for _, tc := range []struct {
+		name      string
+		current   runReport
+		regressed bool
+	}{
+		{"same", report(1000, 100, 200, 400), false},
+		{"faster", report(1200, 120, 240, 480), false},
+		{"within tolerance", report(960, 96, 192, 384), false},
+		{"maxRps fell", report(900, 100, 200, 400), true},
+		{"a level fell", report(1000, 100, 150, 400), true},
+		// Levels only one run measured aren't compared.
+		{"fewer levels", report(1000, 100, 200), false},
+	} {
+		var buf bytes.Buffer
+		if got := compareReports(&buf, baseline, tc.current, 0.05); got != tc.regressed {
+			t.Errorf("%s: got regressed %t, want %t:\n%s", tc.name, got, tc.regressed, buf.String())
+		}
+		if got := strings.Contains(buf.String(), "regressed"); got != tc.regressed {
+			t.Errorf("%s: marked a regression %t, want %t:\n%s", tc.name, got, tc.regressed, buf.String())
+		}
+	}
+}
+
+// A report is read back as it was written, with null for a coefficient the
+// fit couldn't find.
+func TestReadRunReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "run.json")
+
+	r := runReport{modelReport: modelReport{MaxRps: 1000, modelCoefficients: newModelCoefficients(unfittedModel)}}
+	r.Levels = []levelReport{{Concurrency: 4, Throughput: 400}}
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReport(f, r)
+	f.Close()
+
+	got, err := readRunReport(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MaxRps != 1000 || !math.IsNaN(float64(got.Sigma)) || len(got.Levels) != 1 || got.Levels[0].Throughput != 400 {
+		t.Errorf("got %+v, want %+v", got, r)
+	}
+}
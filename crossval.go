@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// crossValidation is how well the model predicts measurements it wasn't
+// fitted to: each level is left out in turn, the model fitted to the rest,
+// and its prediction for the level left out compared with what was
+// measured there.
+// Unlike R² and the RMSE, this penalizes a model that fits the measurements
+// well but predicts poorly, so it's a better guide to how far its
+// extrapolations can be trusted.
+type crossValidation struct {
+	// The root mean squared error of the predictions, in requests per
+	// second.
+	RMSE jsonFloat `json:"rmse"`
+	// The mean absolute error of the predictions, as a fraction of the
+	// throughput measured.
+	MeanRelativeError jsonFloat `json:"meanRelativeError"`
+}
+
+// Cross-validates the model fitted by fit with weights, leaving each level
+// out in turn, along with all of its replicates, so that none of what's
+// being predicted is fitted to. It returns nil if there are too few levels
+// to fit the model to the rest.
+func crossValidate(concurrency, throughput, weights []float64, fit fitFunc) *crossValidation {
+	var levels []float64
+	byLevel := make(map[float64][]int)
+	for i, N := range concurrency {
+		if byLevel[N] == nil {
+			levels = append(levels, N)
+		}
+		byLevel[N] = append(byLevel[N], i)
+	}
+	if len(levels) <= 3 {
+		return nil
+	}
+	var sumSquares, sumRelative float64
+	for _, N := range levels {
+		excluded := byLevel[N]
+		c, t := withoutOutliers(concurrency, throughput, excluded)
+		m, _ := fit(c, t, without(weights, excluded))
+		for _, i := range excluded {
+			residual := throughput[i] - m.throughputAt(N)
+			sumSquares += residual * residual
+			sumRelative += math.Abs(residual / throughput[i])
+		}
+	}
+	n := float64(len(concurrency))
+	return &crossValidation{
+		RMSE:              jsonFloat(math.Sqrt(sumSquares / n)),
+		MeanRelativeError: jsonFloat(sumRelative / n),
+	}
+}
+
+func printCrossValidation(w io.Writer, cv *crossValidation) {
+	fmt.Fprintf(w, "leave-one-level-out RMSE: %f, mean error: %.1f%%\n", cv.RMSE, 100*cv.MeanRelativeError)
+}
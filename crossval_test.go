@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCrossValidate(t *testing.T) {
+	truth := uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}
+	levels := []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48}
+	clean := crossValidate(levels, measure(truth, levels, nil), nil, fitWeightedUSL)
+	if clean == nil {
+		t.Fatal("got no cross-validation")
+	}
+	if clean.MeanRelativeError > 0.03 {
+		t.Errorf("got mean error %.1f%% for 1%% noise, want under 3%%", 100*clean.MeanRelativeError)
+	}
+
+	// A wild level is predicted badly from the rest, and pulls the fits
+	// that include it away from the others.
+	wild := crossValidate(levels, measure(truth, levels, map[int]float64{5: 0.5}), nil, fitWeightedUSL)
+	if !(wild.RMSE > 5*clean.RMSE) {
+		t.Errorf("got RMSE %.0f with a wild level, want it far above %.0f", wild.RMSE, clean.RMSE)
+	}
+
+	// Replicates of a level are left out together, so each is predicted
+	// from the other levels alone.
+	var replicated []float64
+	for _, N := range levels {
+		replicated = append(replicated, N, N, N)
+	}
+	cv := crossValidate(replicated, measure(truth, replicated, nil), nil, fitWeightedUSL)
+	if cv.MeanRelativeError > 0.03 {
+		t.Errorf("got mean error %.1f%% for replicated levels, want under 3%%", 100*cv.MeanRelativeError)
+	}
+
+	if cv := crossValidate([]float64{1, 2, 4, 4}, []float64{800, 1500, 2600, 2650}, nil, fitWeightedUSL); cv != nil {
+		t.Errorf("got %+v for three levels, want nil", cv)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Writes the throughput measured at each concurrency level as CSV, along with
+// the errors seen and how long the level took in seconds.
+func writeMeasurements(filename string, results []levelResult) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"concurrency", "throughput", "errors", "duration"})
+	for _, r := range results {
+		w.Write([]string{
+			strconv.Itoa(r.concurrency),
+			strconv.FormatFloat(r.throughput, 'f', 1, 64),
+			strconv.Itoa(r.errors),
+			strconv.FormatFloat(r.duration.Seconds(), 'f', 3, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Reads (concurrency, throughput) pairs from a CSV file, or from stdin if
+// filename is "-". If the first row is a header, the columns are found by
+// name, so that measurements made with other tools such as wrk or fortio
+// can be fitted too: concurrency may also be called connections or threads,
+// and throughput rps or qps. Otherwise the first two columns are used.
+func readMeasurements(filename string) (concurrency, throughput []float64, err error) {
+	var in io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nCol, xCol := 0, 1
+	if len(records) > 0 {
+		if _, err := strconv.ParseFloat(records[0][0], 64); err != nil {
+			nCol, xCol = -1, -1
+			for i, name := range records[0] {
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "concurrency", "connections", "threads":
+					nCol = i
+				case "throughput", "rps", "qps":
+					xCol = i
+				}
+			}
+			if nCol < 0 || xCol < 0 {
+				return nil, nil, fmt.Errorf("%s: header must name 'concurrency' and 'throughput' columns", filename)
+			}
+			records = records[1:]
+		}
+	}
+
+	for i, record := range records {
+		if len(record) <= nCol || len(record) <= xCol {
+			return nil, nil, fmt.Errorf("%s: row %d: too few columns", filename, i+1)
+		}
+		n, err := strconv.ParseFloat(record[nCol], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: row %d: %s", filename, i+1, err)
+		}
+		x, err := strconv.ParseFloat(record[xCol], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: row %d: %s", filename, i+1, err)
+		}
+		concurrency = append(concurrency, n)
+		throughput = append(throughput, x)
+	}
+	if len(concurrency) == 0 {
+		return nil, nil, fmt.Errorf("%s: no measurements", filename)
+	}
+	return concurrency, throughput, nil
+}
+
+// Saves the coefficients of a fitted model as JSON.
+func writeModel(filename string, m uslModel) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// Loads the coefficients of a model saved by writeModel.
+func readModel(filename string) (uslModel, error) {
+	var m uslModel
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Reads a list of targets, one URL per line, resolving each against base so
+// they may also be paths. Blank lines and lines starting with '#' are
+// skipped.
+func readTargets(filename string, base *url.URL) ([]*url.URL, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*url.URL
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, err := url.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %s", filename, i+1, err)
+		}
+		targets = append(targets, base.ResolveReference(ref))
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", filename)
+	}
+	return targets, nil
+}
+
+// Reads a CSV file whose first row names its columns, for use as a data
+// feed.
+func readDataFeed(filename string) (*dataFeed, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s: expected a header and at least one row", filename)
+	}
+
+	feed := &dataFeed{}
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, name := range records[0] {
+			row[name] = record[i]
+		}
+		feed.rows = append(feed.rows, row)
+	}
+	return feed, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const dbSchema = `CREATE TABLE IF NOT EXISTS runs (
+  id INTEGER PRIMARY KEY,
+  started TEXT NOT NULL,
+  command_line TEXT NOT NULL,
+  flags TEXT NOT NULL,
+  sigma REAL,
+  kappa REAL,
+  lambda REAL,
+  max_concurrency REAL,
+  max_rps REAL
+);
+CREATE TABLE IF NOT EXISTS levels (
+  run_id INTEGER NOT NULL REFERENCES runs (id),
+  concurrency INTEGER NOT NULL,
+  replicate INTEGER NOT NULL,
+  throughput REAL NOT NULL,
+  errors INTEGER NOT NULL,
+  duration REAL NOT NULL,
+  latency_p50_ms REAL,
+  latency_p99_ms REAL,
+  PRIMARY KEY (run_id, concurrency, replicate)
+);
+`
+
+// Quotes s as an SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// Formats f as an SQL number, or NULL if it's NaN or infinite.
+func sqlFloat(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "NULL"
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+// Returns an error if the sqlite3 command that appendToDB runs can't be
+// found, so that -db can be checked before the run rather than after it.
+func checkSQLite() error {
+	_, err := exec.LookPath("sqlite3")
+	return err
+}
+
+// Appends the run to the SQLite database at filename, creating it if need
+// be, with the value of every flag in flags, the fitted model and what was
+// measured at each level. There's no SQLite driver to hand, so this is done
+// by the sqlite3 command, which must be on the PATH.
+func appendToDB(filename string, started time.Time, flags *flag.FlagSet, r runReport) error {
+	values := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	encodedFlags, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	var sql bytes.Buffer
+	sql.WriteString(dbSchema)
+	sql.WriteString("BEGIN;\n")
+	fmt.Fprintf(&sql, "INSERT INTO runs (started, command_line, flags, sigma, kappa, lambda, max_concurrency, max_rps) VALUES (%s, %s, %s, %s, %s, %s, %s, %s);\n",
+		sqlString(started.UTC().Format(time.RFC3339Nano)),
+		sqlString(strings.Join(os.Args, " ")),
+		sqlString(string(encodedFlags)),
+		sqlFloat(float64(r.Sigma)), sqlFloat(float64(r.Kappa)), sqlFloat(float64(r.Lambda)),
+		sqlFloat(float64(r.MaxConcurrency)), sqlFloat(float64(r.MaxRps)))
+	for _, level := range r.Levels {
+		fmt.Fprintf(&sql, "INSERT INTO levels VALUES ((SELECT max(id) FROM runs), %d, %d, %s, %d, %s, %s, %s);\n",
+			level.Concurrency, level.Replicate, sqlFloat(level.Throughput), level.Errors,
+			sqlFloat(level.Duration), sqlFloat(level.Latency.P50), sqlFloat(level.Latency.P99))
+	}
+	sql.WriteString("COMMIT;\n")
+
+	cmd := exec.Command("sqlite3", "-bail", filename)
+	cmd.Stdin = &sql
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
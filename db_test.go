@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLLiterals(t *testing.T) {
+	for _, tc := range []struct{ got, want string }{
+		{sqlString("plain"), "'plain'"},
+		{sqlString("it's"), "'it''s'"},
+		{sqlFloat(1.5), "1.5"},
+		{sqlFloat(math.NaN()), "NULL"},
+		{sqlFloat(math.Inf(1)), "NULL"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("got %s, want %s", tc.got, tc.want)
+		}
+	}
+}
+
+func TestAppendToDB(t *testing.T) {
+	if err := checkSQLite(); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "runs.db")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("address", "http://o'brien:8080/", "")
+	levels := []levelReport{{Concurrency: 1, Throughput: 100}, {Concurrency: 2, Throughput: 190}, {Concurrency: 2, Replicate: 1, Throughput: 185}}
+	fitted := runReport{Levels: levels, modelReport: modelReport{modelCoefficients: modelCoefficients{0.05, 0.001, 100}, MaxRps: 1000, MaxConcurrency: 30}}
+	// A second run, with a fit that failed.
+	failed := runReport{Levels: levels, modelReport: modelReport{modelCoefficients: newModelCoefficients(unfittedModel), MaxRps: 190, MaxConcurrency: 2}}
+	for _, r := range []runReport{fitted, failed} {
+		if err := appendToDB(filename, time.Now(), flags, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(sql string) string {
+		out, err := exec.Command("sqlite3", filename, sql).Output()
+		if err != nil {
+			t.Fatalf("%s: %s", sql, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	for _, tc := range []struct{ sql, want string }{
+		{"SELECT count(*) FROM runs", "2"},
+		{"SELECT count(*) FROM levels", "6"},
+		{"SELECT count(*) FROM levels WHERE run_id = 2", "3"},
+		{"SELECT sigma = 0.05, max_rps = 1000 FROM runs WHERE id = 1", "1|1"},
+		{"SELECT sigma IS NULL, kappa IS NULL, lambda IS NULL, max_rps = 190 FROM runs WHERE id = 2", "1|1|1|1"},
+		{"SELECT json_extract(flags, '$.address') FROM runs WHERE id = 1", "http://o'brien:8080/"},
+	} {
+		if got := query(tc.sql); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.sql, got, tc.want)
+		}
+	}
+}
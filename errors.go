@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// errorCategory is the kind of failure a request ran into, so that errors
+// can be counted by cause.
+type errorCategory int
+
+const (
+	errorDNS errorCategory = iota
+	errorConnect
+	errorTLS
+	errorTimeout
+	errorReset
+	errorHTTP
+	errorTruncated
+	errorOther
+	errorCategories
+)
+
+var errorCategoryNames = [errorCategories]string{"dns", "connect", "tls", "timeout", "reset", "http", "truncated", "other"}
+
+func (c errorCategory) String() string {
+	return errorCategoryNames[c]
+}
+
+// httpError is returned when a response arrived but wasn't the one
+// expected, such as when it had the wrong status or body.
+type httpError struct {
+	error
+}
+
+// Works out which category err falls in.
+func categorizeError(err error) errorCategory {
+	var (
+		truncated    truncatedBodyError
+		httpErr      httpError
+		dns          *net.DNSError
+		netErr       net.Error
+		recordHeader tls.RecordHeaderError
+		verification *tls.CertificateVerificationError
+		unknownAuth  x509.UnknownAuthorityError
+		hostname     x509.HostnameError
+		invalidCert  x509.CertificateInvalidError
+		op           *net.OpError
+	)
+	switch {
+	case errors.As(err, &truncated):
+		return errorTruncated
+	case errors.As(err, &httpErr):
+		return errorHTTP
+	case errors.As(err, &dns):
+		return errorDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errorTimeout
+	case errors.As(err, &recordHeader), errors.As(err, &verification), errors.As(err, &unknownAuth),
+		errors.As(err, &hostname), errors.As(err, &invalidCert), strings.Contains(err.Error(), "tls: "),
+		strings.Contains(err.Error(), "HTTP response to HTTPS client"):
+		return errorTLS
+	case errors.As(err, &op) && op.Op == "dial":
+		return errorConnect
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return errorReset
+	}
+	return errorOther
+}
+
+// errorCounts counts errors by category.
+type errorCounts [errorCategories]int
+
+func (c *errorCounts) add(o errorCounts) {
+	for i, n := range o {
+		c[i] += n
+	}
+}
+
+// Describes the count of each category that had any errors, such as
+// "connect 3, timeout 12".
+func (c *errorCounts) String() string {
+	var categories []string
+	for i, n := range c {
+		if n > 0 {
+			categories = append(categories, fmt.Sprintf("%s %d", errorCategory(i), n))
+		}
+	}
+	return strings.Join(categories, ", ")
+}
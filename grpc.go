@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Returns message, an already serialized protobuf message, prefixed as gRPC
+// sends it: with a byte saying whether it's compressed and its length. An
+// empty message has every field at its default value, which makes it a
+// valid request for many methods, including grpc.health.v1.Health/Check.
+func grpcFrame(message []byte, compressed bool) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	if compressed {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcStatusError is returned by sendGRPCRequest when a call completes with
+// a status other than OK.
+type grpcStatusError struct {
+	code    string
+	message string
+}
+
+func (e grpcStatusError) Error() string {
+	return fmt.Sprintf("grpc status %s: %s", e.code, e.message)
+}
+
+// Makes a unary gRPC call to the method at t.url with t.body as the request
+// message, gzipped with -compressBody. gRPC needs HTTP/2, so client must use
+// a transport that speaks it.
+func sendGRPCRequest(
+	s *session,
+	t *requestType,
+	opts *loadTestOptions,
+) error {
+	req, err := http.NewRequest("POST", t.url.String(), bytes.NewReader(grpcFrame(t.body, opts.compressBody)))
+	if err != nil {
+		return err
+	}
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if opts.host != "" {
+		req.Host = opts.host
+	}
+
+	response, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if _, err := io.CopyBuffer(ioutil.Discard, response.Body, s.bodyBuffer); err != nil {
+		return truncatedBodyError{err}
+	}
+	if response.StatusCode != http.StatusOK {
+		return httpError{fmt.Errorf("unexpected HTTP status %s", response.Status)}
+	}
+
+	// Trailers-only responses carry the status in the headers instead.
+	status, message := response.Trailer.Get("Grpc-Status"), response.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = response.Header.Get("Grpc-Status"), response.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return grpcStatusError{status, message}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// A gRPC server that answers a call with OK if its request message is the
+// one it expects, decompressing it if it's flagged as compressed, and with
+// INVALID_ARGUMENT otherwise.
+func grpcServer(t *testing.T, want []byte) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		status := "3"
+		if len(body) >= 5 && int(binary.BigEndian.Uint32(body[1:5])) == len(body)-5 {
+			message := body[5:]
+			if body[0] == 1 && r.Header.Get("Grpc-Encoding") == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(message))
+				if err == nil {
+					message, err = ioutil.ReadAll(zr)
+				}
+				if err != nil {
+					message = nil
+				}
+			}
+			if r.Header.Get("Content-Encoding") == "" && bytes.Equal(message, want) {
+				status = "0"
+			}
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Grpc-Status", status)
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	return server
+}
+
+func TestSendGRPCRequest(t *testing.T) {
+	// A serialized message with field 1 set to the string "http-max-rps".
+	message := append([]byte{0x0a, 12}, "http-max-rps"...)
+	for _, tc := range []struct {
+		name     string
+		body     []byte
+		compress bool
+		want     []byte
+	}{
+		{"empty", nil, false, nil},
+		{"message", message, false, message},
+		{"compressed", gzipBody(message), true, message},
+		{"wrong message", []byte{0x0a, 0}, false, message},
+	} {
+		server := grpcServer(t, tc.want)
+		u, _ := url.Parse(server.URL + "/test.Service/Method")
+		opts := &loadTestOptions{compressBody: tc.compress}
+		header := make(http.Header)
+		if tc.compress {
+			header.Set("Grpc-Encoding", "gzip")
+		}
+		s := &session{client: newClient(newTransport(&transportOptions{http2: true}, 1, nil)), bodyBuffer: make([]byte, 512)}
+		err := sendGRPCRequest(s, &requestType{url: u, header: header, body: tc.body}, opts)
+		if tc.name == "wrong message" {
+			if _, ok := err.(grpcStatusError); !ok {
+				t.Errorf("%s: got %v, want a gRPC status error", tc.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// The cookies that begin a histogram encoded in HdrHistogram's V2 format,
+// uncompressed and compressed.
+const (
+	hdrEncodingCookie           = 0x1c849303 | 0x10
+	hdrCompressedEncodingCookie = 0x1c849304 | 0x10
+)
+
+// The layout of a histogram, after HdrHistogram: values are bucketed by
+// powers of two, each split into sub-buckets fine enough to keep three
+// significant digits.
+const (
+	subBucketBits      = 11
+	subBucketCount     = 1 << subBucketBits
+	subBucketHalfBits  = subBucketBits - 1
+	subBucketHalfCount = 1 << subBucketHalfBits
+	subBucketMask      = subBucketCount - 1
+	// Enough buckets for values up to 2^32 microseconds, over an hour.
+	histogramBuckets = 32 - subBucketBits + 1
+	histogramMax     = 1<<32 - 1
+)
+
+// histogram is a latencySketch after HdrHistogram, recording latencies in
+// microseconds to three significant digits.
+type histogram struct {
+	latencyStats
+	counts []int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, (histogramBuckets+1)*subBucketHalfCount)}
+}
+
+func countsIndex(v int64) int {
+	bucket := bits.Len64(uint64(v)|subBucketMask) - subBucketBits
+	subBucket := int(v >> uint(bucket))
+	return (bucket+1)<<subBucketHalfBits + subBucket - subBucketHalfCount
+}
+
+// Returns the lowest and highest values that fall in the same slot as the
+// one at index i of counts.
+func valueRange(i int) (lowest, highest int64) {
+	bucket := i>>subBucketHalfBits - 1
+	subBucket := i&(subBucketHalfCount-1) + subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= subBucketHalfCount
+		bucket = 0
+	}
+	lowest = int64(subBucket) << uint(bucket)
+	return lowest, lowest + 1<<uint(bucket) - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	h.recordStats(d)
+	v := int64(d / time.Microsecond)
+	if v < 0 {
+		v = 0
+	}
+	if v > histogramMax {
+		v = histogramMax
+	}
+	h.counts[countsIndex(v)]++
+}
+
+func (h *histogram) merge(o latencySketch) {
+	oh := o.(*histogram)
+	h.mergeStats(&oh.latencyStats)
+	for i, n := range oh.counts {
+		h.counts[i] += n
+	}
+}
+
+// Returns the latency that percentile percent of those recorded are at or
+// below, or 0 if none have been recorded.
+func (h *histogram) valueAtPercentile(percent float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	target := int64(percent/100*float64(h.n) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= target {
+			_, highest := valueRange(i)
+			return time.Duration(highest) * time.Microsecond
+		}
+	}
+	return 0
+}
+
+// Returns one more than the index of the last non-zero count, or 1 if
+// nothing has been recorded.
+func (h *histogram) countsLimit() int {
+	for i := len(h.counts) - 1; i >= 0; i-- {
+		if h.counts[i] != 0 {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// Encodes h in HdrHistogram's compressed V2 format, as used by its log
+// files: a header followed by the counts, ZigZag LEB128 encoded with runs of
+// zeros collapsed, all deflated.
+func (h *histogram) encode() ([]byte, error) {
+	var payload bytes.Buffer
+	var varint [binary.MaxVarintLen64]byte
+	limit := h.countsLimit()
+	for i := 0; i < limit; {
+		count := h.counts[i]
+		i++
+		zeros := int64(0)
+		if count == 0 {
+			zeros = 1
+			for i < limit && h.counts[i] == 0 {
+				zeros++
+				i++
+			}
+		}
+		if zeros > 1 {
+			count = -zeros
+		}
+		payload.Write(varint[:binary.PutVarint(varint[:], count)])
+	}
+
+	var encoded bytes.Buffer
+	binary.Write(&encoded, binary.BigEndian, struct {
+		Cookie                 int32
+		PayloadLength          int32
+		NormalizingIndexOffset int32
+		SignificantDigits      int32
+		LowestDiscernibleValue int64
+		HighestTrackableValue  int64
+		ConversionRatio        float64
+	}{hdrEncodingCookie, int32(payload.Len()), 0, 3, 1, histogramMax, 1})
+	encoded.Write(payload.Bytes())
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(encoded.Bytes())
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, []int32{hdrCompressedEncodingCookie, int32(compressed.Len())})
+	out.Write(compressed.Bytes())
+	return out.Bytes(), nil
+}
+
+// Writes the latency histogram of each level to filename in HdrHistogram's
+// log format, tagged by concurrency, so that runs can be merged and compared
+// with its tools. Latencies are in microseconds.
+func writeHistogramLog(filename string, start time.Time, results []levelResult) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "#[Histogram log format version 1.3]\n")
+	fmt.Fprintf(f, "#[StartTime: %.3f (seconds since epoch), %s]\n", float64(start.UnixNano())/1e9, start.Format(time.RFC1123))
+	fmt.Fprintf(f, "#[Latencies are in microseconds, Interval_Max in milliseconds]\n")
+	fmt.Fprintf(f, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	for _, r := range results {
+		encoded, err := r.latency.(*histogram).encode()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "Tag=concurrency-%d,%.3f,%.3f,%.3f,%s\n",
+			r.concurrency,
+			r.start.Sub(start).Seconds(),
+			r.duration.Seconds(),
+			r.latency.max().Seconds()*1000,
+			base64.StdEncoding.EncodeToString(encoded))
+	}
+	return f.Close()
+}
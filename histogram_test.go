@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Decodes a histogram encoded in HdrHistogram's compressed V2 format back
+// into its counts, checking the cookies and lengths on the way.
+func decodeHistogram(t *testing.T, encoded []byte) []int64 {
+	var outer struct{ Cookie, Length int32 }
+	r := bytes.NewReader(encoded)
+	if err := binary.Read(r, binary.BigEndian, &outer); err != nil {
+		t.Fatalf("reading the compressed header: %s", err)
+	}
+	if outer.Cookie != hdrCompressedEncodingCookie || int(outer.Length) != r.Len() {
+		t.Fatalf("compressed header: cookie %#x, length %d of %d", outer.Cookie, outer.Length, r.Len())
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		t.Fatalf("inflating: %s", err)
+	}
+	inflated, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("inflating: %s", err)
+	}
+
+	var header struct {
+		Cookie                 int32
+		PayloadLength          int32
+		NormalizingIndexOffset int32
+		SignificantDigits      int32
+		LowestDiscernibleValue int64
+		HighestTrackableValue  int64
+		ConversionRatio        float64
+	}
+	r = bytes.NewReader(inflated)
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		t.Fatalf("reading the header: %s", err)
+	}
+	if header.Cookie != hdrEncodingCookie || int(header.PayloadLength) != r.Len() ||
+		header.SignificantDigits != 3 || header.HighestTrackableValue != histogramMax {
+		t.Fatalf("unexpected header %+v with %d bytes of payload", header, r.Len())
+	}
+
+	var counts []int64
+	for r.Len() > 0 {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatalf("reading counts: %s", err)
+		}
+		if count < 0 {
+			counts = append(counts, make([]int64, -count)...)
+		} else {
+			counts = append(counts, count)
+		}
+	}
+	return counts
+}
+
+func TestHistogramEncode(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		latencies []time.Duration
+	}{
+		{"empty", nil},
+		{"one", []time.Duration{time.Millisecond}},
+		{"repeated", []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}},
+		{"spread", []time.Duration{0, time.Microsecond, 250 * time.Microsecond, 3 * time.Millisecond, 40 * time.Millisecond, 2 * time.Second}},
+		{"beyond the maximum", []time.Duration{2 * time.Hour}},
+	} {
+		h := newHistogram()
+		for _, d := range tc.latencies {
+			h.record(d)
+		}
+		encoded, err := h.encode()
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		got := decodeHistogram(t, encoded)
+		want := h.counts[:h.countsLimit()]
+		if len(got) != len(want) {
+			t.Errorf("%s: decoded %d counts, want %d", tc.name, len(got), len(want))
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: count %d is %d, want %d", tc.name, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// influxRecorder samples the progress of a run once a second, keeping each
+// sample as a line of InfluxDB line protocol.
+type influxRecorder struct {
+	live    *liveStats
+	lines   bytes.Buffer
+	done    chan struct{}
+	stopped chan struct{}
+
+	// The counters at the last sample, to record only the difference.
+	requests     uint64
+	errors       uint64
+	latencySumNs uint64
+}
+
+func newInfluxRecorder(live *liveStats) *influxRecorder {
+	r := &influxRecorder{live: live, done: make(chan struct{}), stopped: make(chan struct{})}
+	go r.run()
+	return r
+}
+
+func (r *influxRecorder) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.sample(now)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Records the requests, errors and mean latency since the last sample,
+// tagged with the concurrency at the time. Nothing is recorded between
+// levels.
+func (r *influxRecorder) sample(now time.Time) {
+	requests := atomic.LoadUint64(&r.live.requests)
+	errors := atomic.LoadUint64(&r.live.errors)
+	latencySumNs := atomic.LoadUint64(&r.live.latencySumNs)
+	concurrency := atomic.LoadInt64(&r.live.concurrency)
+	n, sum := requests-r.requests, latencySumNs-r.latencySumNs
+	e := errors - r.errors
+	r.requests, r.errors, r.latencySumNs = requests, errors, latencySumNs
+	if concurrency == 0 {
+		return
+	}
+
+	var meanMs float64
+	if n > 0 {
+		meanMs = float64(sum) / float64(n) / 1e6
+	}
+	fmt.Fprintf(&r.lines, "http_max_rps,concurrency=%d requests=%di,errors=%di,latency_mean_ms=%g %d\n",
+		concurrency, n, e, meanMs, now.UnixNano())
+}
+
+// Stops sampling, returning the samples taken.
+func (r *influxRecorder) stop() []byte {
+	close(r.done)
+	<-r.stopped
+	return r.lines.Bytes()
+}
+
+// Writes lines of line protocol to dest, which is either a file or the
+// http(s) URL of an InfluxDB write endpoint, such as
+// http://localhost:8086/write?db=perf.
+func writeInflux(dest string, lines []byte) error {
+	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		return ioutil.WriteFile(dest, lines, 0644)
+	}
+
+	response, err := (&http.Client{Timeout: 10 * time.Second}).Post(dest, "text/plain; charset=utf-8", bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("influx responded %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// The subset of the JUnit XML format that CI systems render.
+type (
+	junitTestSuite struct {
+		XMLName   xml.Name        `xml:"testsuite"`
+		Name      string          `xml:"name,attr"`
+		Tests     int             `xml:"tests,attr"`
+		Failures  int             `xml:"failures,attr"`
+		Time      float64         `xml:"time,attr"`
+		TestCases []junitTestCase `xml:"testcase"`
+	}
+	junitTestCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      float64       `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+	}
+)
+
+// Writes a JUnit XML report to filename with a test case for each level,
+// which fails if its error rate is above maxErrorRate, and one for each of
+// checks.
+func writeJUnit(filename string, results []levelResult, maxErrorRate float64, checks []slaCheck) error {
+	suite := junitTestSuite{Name: "http-max-rps"}
+	for _, r := range results {
+		name := fmt.Sprintf("concurrency %d", r.concurrency)
+		if r.replicate > 0 {
+			name += fmt.Sprintf(" replicate %d", r.replicate)
+		}
+		c := junitTestCase{
+			Name:      name,
+			ClassName: "http-max-rps.levels",
+			Time:      r.duration.Seconds(),
+			SystemOut: fmt.Sprintf("throughput %.1f rps, %d errors", r.throughput, r.errors),
+		}
+		if rate, ok := errorRate(r); maxErrorRate >= 0 && ok && rate > maxErrorRate {
+			c.Failure = &junitFailure{fmt.Sprintf("error rate %.4f is above -maxErrorRate %g", rate, maxErrorRate)}
+		}
+		suite.TestCases = append(suite.TestCases, c)
+		suite.Time += c.Time
+	}
+	for _, check := range checks {
+		c := junitTestCase{Name: check.name, ClassName: "http-max-rps.sla"}
+		if check.failure != "" {
+			c.Failure = &junitFailure{check.failure}
+		}
+		suite.TestCases = append(suite.TestCases, c)
+	}
+	for _, c := range suite.TestCases {
+		suite.Tests++
+		if c.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	f.WriteString(xml.Header)
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	f.WriteString("\n")
+	return f.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// latencySketch summarizes the latencies of a level's requests in a fixed
+// amount of memory, so that percentiles can be estimated however many
+// there were. Sketches of the same kind can be merged.
+type latencySketch interface {
+	record(d time.Duration)
+	// Adds the latencies recorded in o, which must be the same kind of
+	// sketch.
+	merge(o latencySketch)
+	// Returns the latency that percentile percent of those recorded are at
+	// or below, or 0 if none have been recorded.
+	valueAtPercentile(percent float64) time.Duration
+	count() int64
+	min() time.Duration
+	max() time.Duration
+	mean() time.Duration
+	stddev() time.Duration
+}
+
+// Returns a function that makes sketches of the kind named by -latencySketch.
+func latencySketchMaker(kind string) (func() latencySketch, bool) {
+	switch kind {
+	case "hdr":
+		return func() latencySketch { return newHistogram() }, true
+	case "tdigest":
+		return func() latencySketch { return newTDigest(tdigestCompression) }, true
+	}
+	return nil, false
+}
+
+// latencyStats keeps the count, extremes, mean and standard deviation of
+// latencies exactly, for sketches to embed.
+type latencyStats struct {
+	n                  int64
+	minValue, maxValue time.Duration
+	// The sum of the latencies recorded and of their squares, in seconds.
+	sum, sumSquares float64
+}
+
+func (s *latencyStats) recordStats(d time.Duration) {
+	if s.n == 0 || d < s.minValue {
+		s.minValue = d
+	}
+	if d > s.maxValue {
+		s.maxValue = d
+	}
+	s.n++
+	s.sum += d.Seconds()
+	s.sumSquares += d.Seconds() * d.Seconds()
+}
+
+func (s *latencyStats) mergeStats(o *latencyStats) {
+	if o.n == 0 {
+		return
+	}
+	if s.n == 0 || o.minValue < s.minValue {
+		s.minValue = o.minValue
+	}
+	if o.maxValue > s.maxValue {
+		s.maxValue = o.maxValue
+	}
+	s.n += o.n
+	s.sum += o.sum
+	s.sumSquares += o.sumSquares
+}
+
+func (s *latencyStats) count() int64 {
+	return s.n
+}
+
+// Returns the lowest latency recorded, or 0 if none have been.
+func (s *latencyStats) min() time.Duration {
+	return s.minValue
+}
+
+// Returns the highest latency recorded, or 0 if none have been.
+func (s *latencyStats) max() time.Duration {
+	return s.maxValue
+}
+
+// Returns the mean latency recorded, or 0 if none have been.
+func (s *latencyStats) mean() time.Duration {
+	if s.n == 0 {
+		return 0
+	}
+	return time.Duration(s.sum / float64(s.n) * float64(time.Second))
+}
+
+// Returns the population standard deviation of the latencies recorded.
+func (s *latencyStats) stddev() time.Duration {
+	if s.n == 0 {
+		return 0
+	}
+	n := float64(s.n)
+	mean := s.sum / n
+	variance := math.Max(s.sumSquares/n-mean*mean, 0)
+	return time.Duration(math.Sqrt(variance) * float64(time.Second))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Throughput and latency fits whose maxConcurrency or maxRps differ by more
+// than this fraction disagree.
+const maxFitDisagreement = 0.25
+
+// latencyFit is the model fitted to the mean latency measured at each level
+// rather than the throughput, with the maxima it implies.
+type latencyFit struct {
+	modelCoefficients
+	MaxConcurrency jsonFloat `json:"maxConcurrency"`
+	MaxRps         jsonFloat `json:"maxRps"`
+	// Whether its maxima differ from the throughput fit's by more than
+	// maxFitDisagreement, which suggests the measurements are off.
+	Disagrees bool `json:"disagrees"`
+}
+
+// Fits the latency form of the USL, R(N) = (1 + sigma*(N-1) +
+// kappa*N*(N-1)) / lambda, to the mean latency, in seconds, measured at
+// each concurrency level. Expanded, R(N) is a quadratic in N, so it's
+// fitted by linear least squares to a + b*N + c*N², from which lambda =
+// 1/(a+b+c), kappa = c*lambda and sigma = b*lambda + kappa.
+func fitLatencyUSL(concurrency, latency []float64) (uslModel, error) {
+	if len(concurrency) < 3 {
+		return uslModel{}, fmt.Errorf("needs at least 3 levels, got %d", len(concurrency))
+	}
+	a := mat.NewDense(len(concurrency), 3, nil)
+	for i, N := range concurrency {
+		a.SetRow(i, []float64{1, N, N * N})
+	}
+	var coef mat.Dense
+	if err := coef.Solve(a, mat.NewDense(len(latency), 1, latency)); err != nil {
+		return uslModel{}, err
+	}
+	r1 := coef.At(0, 0) + coef.At(1, 0) + coef.At(2, 0)
+	if !(r1 > 0) {
+		return uslModel{}, fmt.Errorf("latency at concurrency 1 would be %g", r1)
+	}
+	lambda := 1 / r1
+	kappa := coef.At(2, 0) * lambda
+	return uslModel{Sigma: coef.At(1, 0)*lambda + kappa, Kappa: kappa, Lambda: lambda}, nil
+}
+
+// Fits the latency form of the USL and compares its maxima with those of
+// the throughput fit, m.
+func newLatencyFit(concurrency, latency []float64, m uslModel) (*latencyFit, error) {
+	lm, err := fitLatencyUSL(concurrency, latency)
+	if err != nil {
+		return nil, err
+	}
+	differs := func(x, y float64) bool {
+		return !(math.Abs(x-y) <= maxFitDisagreement*math.Abs(y))
+	}
+	return &latencyFit{
+		modelCoefficients: newModelCoefficients(lm),
+		MaxConcurrency:    jsonFloat(lm.maxConcurrency()),
+		MaxRps:            jsonFloat(lm.maxRps()),
+		Disagrees:         differs(lm.maxConcurrency(), m.maxConcurrency()) || differs(lm.maxRps(), m.maxRps()),
+	}, nil
+}
+
+func printLatencyFit(w io.Writer, f *latencyFit) {
+	fmt.Fprintf(w, "latency fit: sigma %g, kappa %g, lambda %g, maxConcurrency %.0f, maxRps %.0f\n",
+		float64(f.Sigma), float64(f.Kappa), float64(f.Lambda), float64(f.MaxConcurrency), float64(f.MaxRps))
+}
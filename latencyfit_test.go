@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns whether got is within tolerance of want, relative to want or, if
+// want is 0, absolutely.
+func near(got, want, tolerance float64) bool {
+	if want == 0 {
+		return math.Abs(got) <= tolerance
+	}
+	return math.Abs(got-want) <= tolerance*math.Abs(want)
+}
+
+func TestFitLatencyUSL(t *testing.T) {
+	levels := []float64{1, 2, 4, 8, 16, 32, 64}
+	for _, want := range []uslModel{
+		{Sigma: 0.05, Kappa: 0.0001, Lambda: 800},
+		{Sigma: 0.2, Kappa: 0.001, Lambda: 5000},
+		{Sigma: 0.01, Kappa: 0, Lambda: 100},
+	} {
+		var latency []float64
+		for _, N := range levels {
+			latency = append(latency, N/want.throughputAt(N))
+		}
+		got, err := fitLatencyUSL(levels, latency)
+		if err != nil {
+			t.Errorf("%+v: %s", want, err)
+			continue
+		}
+		if !near(got.Sigma, want.Sigma, 1e-6) || !near(got.Kappa, want.Kappa, 1e-6) || !near(got.Lambda, want.Lambda, 1e-6) {
+			t.Errorf("fitted %+v to the latency of %+v", got, want)
+		}
+	}
+}
+
+func TestFitLatencyUSLErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		concurrency, latency []float64
+	}{
+		{"too few levels", []float64{1, 2}, []float64{0.001, 0.002}},
+		// Latency falling as concurrency rises fits a curve with negative
+		// latency at concurrency 1.
+		{"negative latency", []float64{1, 2, 3}, []float64{-0.003, 0.002, 0.001}},
+	} {
+		if m, err := fitLatencyUSL(tc.concurrency, tc.latency); err == nil {
+			t.Errorf("%s: fitted %+v, want an error", tc.name, m)
+		}
+	}
+}
@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Converts a slice of chan workerResult to a slice of workerResult.
+func chansToSlice(cs []<-chan workerResult, size int) []workerResult {
+	s := make([]workerResult, size)
+	for i, c := range cs {
+		for m := range c {
+			s[i] = m
+		}
+	}
+	return s
+}
+
+// transportOptions configures the transport built by newTransport.
+type transportOptions struct {
+	noreuse bool
+	http2   bool
+	// Skip verification of the target's certificate.
+	insecure bool
+	// CAs to verify the target's certificate against. If nil, the host's
+	// root CAs are used.
+	rootCAs *x509.CertPool
+	// If set, connections are made through this proxy rather than any
+	// configured in the environment.
+	proxyURL *url.URL
+	// Ignore any proxy configured in the environment.
+	noProxyEnv bool
+	// The network to dial, "tcp4" or "tcp6", to force an address family.
+	// If empty, either is used.
+	network string
+	// Local addresses to make connections from, used in turn.
+	sourceIPs []net.IP
+	// Resolves hostnames when dialing. If nil, the host's resolver is used.
+	resolver *net.Resolver
+	// Addresses, as host:port, to connect to in place of those requested.
+	resolve map[string]string
+	// If set, every connection is made to this unix domain socket
+	// regardless of the address being requested.
+	unixSocket string
+	// Client certificates to present to servers that ask for them.
+	certificates []tls.Certificate
+	// The server name to send in the TLS handshake and verify the target's
+	// certificate against. If empty, the requested host is used.
+	serverName string
+	// The range of TLS versions to offer; zero leaves it to crypto/tls.
+	minTLSVersion uint16
+	maxTLSVersion uint16
+	// The cipher suites to offer for TLS 1.2 and below. If nil, crypto/tls
+	// chooses.
+	cipherSuites []uint16
+	// Cache session tickets so that new connections can resume earlier
+	// TLS sessions instead of making a full handshake.
+	sessionTickets bool
+}
+
+// tlsStats counts the TLS handshakes made with a configuration.
+type tlsStats struct {
+	handshakes uint64
+	resumed    uint64
+}
+
+// Returns the TLS configuration for connections to the target. If stats is
+// non-nil, handshakes made with the configuration are counted in it.
+func (opts *transportOptions) tlsConfig(stats *tlsStats) *tls.Config {
+	config := &tls.Config{
+		ServerName:         opts.serverName,
+		Certificates:       opts.certificates,
+		RootCAs:            opts.rootCAs,
+		InsecureSkipVerify: opts.insecure,
+		MinVersion:         opts.minTLSVersion,
+		MaxVersion:         opts.maxTLSVersion,
+		CipherSuites:       opts.cipherSuites,
+	}
+	if opts.sessionTickets {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if stats != nil {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			atomic.AddUint64(&stats.handshakes, 1)
+			if state.DidResume {
+				atomic.AddUint64(&stats.resumed, 1)
+			}
+			return nil
+		}
+	}
+	return config
+}
+
+func newTransport(opts *transportOptions, maxConn int, stats *tlsStats) *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if opts.noProxyEnv {
+		proxy = nil
+	}
+	if opts.proxyURL != nil {
+		proxy = http.ProxyURL(opts.proxyURL)
+	}
+
+	tr := http.Transport{
+		// Compressed responses are asked for and decoded by sendRequest,
+		// which counts the bytes before and after.
+		DisableCompression:  true,
+		DisableKeepAlives:   opts.noreuse,
+		MaxIdleConnsPerHost: maxConn,
+		Proxy:               proxy,
+		Dial:                newDialer(opts),
+		TLSClientConfig:     opts.tlsConfig(stats),
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+	if opts.http2 {
+		// Only speak HTTP/2 so that a server which can't is reported as
+		// failing rather than silently measured over HTTP/1.1: h2 via ALPN
+		// for https targets and h2c with prior knowledge otherwise.
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		tr.Protocols = protocols
+	}
+	return &tr
+}
+
+// Returns a function that dials connections as opts directs: to the unix
+// socket or the overridden address if there is one, over the network
+// forced, and from each of the source addresses in turn.
+func newDialer(opts *transportOptions) func(network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:  5 * time.Second,
+		Resolver: opts.resolver,
+	}
+	dialers := []*net.Dialer{dialer}
+	if len(opts.sourceIPs) > 0 {
+		dialers = nil
+		for _, ip := range opts.sourceIPs {
+			d := *dialer
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+			dialers = append(dialers, &d)
+		}
+	}
+	var dials uint32
+	return func(network, addr string) (net.Conn, error) {
+		if opts.unixSocket != "" {
+			return dialer.Dial("unix", opts.unixSocket)
+		}
+		if to, ok := opts.resolve[addr]; ok {
+			addr = to
+		}
+		if opts.network != "" {
+			network = opts.network
+		}
+		d := dialers[atomic.AddUint32(&dials, 1)%uint32(len(dialers))]
+		return d.Dial(network, addr)
+	}
+}
+
+// Returns the host:port that requests to u connect to.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" || u.Scheme == "wss" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func newClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+	}
+}
+
+// truncatedBodyError is returned by sendRequest when the response body
+// could not be read in full, e.g. because the server closed the connection
+// before sending everything it promised.
+type truncatedBodyError struct {
+	err error
+}
+
+func (e truncatedBodyError) Error() string {
+	return "truncated response body: " + e.err.Error()
+}
+
+// session is the state a worker keeps between the requests it sends.
+type session struct {
+	client     *http.Client
+	bodyBuffer []byte
+	// The validators of each URL's last response, by URL, for making
+	// conditional requests. Nil unless opts.conditional is set.
+	validators map[string]validators
+	// Holds the response body when it has to be checked.
+	body bytes.Buffer
+	// Hashes the response body when its checksum is verified.
+	hash  hash.Hash
+	stats responseStats
+}
+
+// validators are what a response offers for revalidating it later.
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// responseStats tallies the responses a worker has received.
+type responseStats struct {
+	responses int
+	// Responses by status class, 1xx to 5xx.
+	statusClasses [5]int
+	notModified   int
+	mismatched    int
+	// Redirects followed, or returned when they're not followed.
+	redirects int
+	// Body bytes as received, and once decompressed.
+	received int64
+	decoded  int64
+}
+
+// Describes the responses in each status class that had any, such as
+// "2xx 1200, 5xx 30".
+func (s *responseStats) describeStatusClasses() string {
+	var classes []string
+	for i, n := range s.statusClasses {
+		if n > 0 {
+			classes = append(classes, fmt.Sprintf("%dxx %d", i+1, n))
+		}
+	}
+	return strings.Join(classes, ", ")
+}
+
+func (s *responseStats) add(o responseStats) {
+	s.responses += o.responses
+	for i, n := range o.statusClasses {
+		s.statusClasses[i] += n
+	}
+	s.notModified += o.notModified
+	s.mismatched += o.mismatched
+	s.redirects += o.redirects
+	s.received += o.received
+	s.decoded += o.decoded
+}
+
+func sendRequest(
+	s *session,
+	t *requestType,
+	opts *loadTestOptions,
+) error {
+	var body io.Reader
+	if len(t.body) > 0 {
+		body = bytes.NewReader(t.body)
+	}
+	target := t.url.String()
+	req, err := http.NewRequest(t.method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Close = false
+	if opts.chunkSize > 0 && len(t.body) > 0 {
+		// An unknown length makes the body go out chunked.
+		req.Body = ioutil.NopCloser(&chunkReader{body: t.body, size: opts.chunkSize})
+		req.ContentLength = -1
+	}
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	if opts.host != "" {
+		req.Host = opts.host
+	}
+	if opts.acceptGzip {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if v, ok := s.validators[target]; ok {
+		if v.etag != "" {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if v.lastModified != "" {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+
+	var span *otlpSpan
+	if opts.tracer != nil {
+		span = opts.tracer.start(req)
+	}
+
+	response, err := s.client.Do(req)
+
+	if err != nil {
+		if span != nil {
+			opts.tracer.end(span, 0, err)
+		}
+		return err
+	} else {
+		defer response.Body.Close()
+		if span != nil {
+			// The span covers reading the body too.
+			defer opts.tracer.end(span, response.StatusCode, nil)
+		}
+		s.stats.responses++
+		if class := response.StatusCode / 100; class >= 1 && class <= len(s.stats.statusClasses) {
+			s.stats.statusClasses[class-1]++
+		}
+		if isRedirect(response.StatusCode) {
+			s.stats.redirects++
+		}
+		if response.StatusCode == http.StatusNotModified {
+			s.stats.notModified++
+		} else if s.validators != nil {
+			v := validators{response.Header.Get("ETag"), response.Header.Get("Last-Modified")}
+			if v.etag != "" || v.lastModified != "" {
+				s.validators[target] = v
+			}
+		}
+
+		received := &countingReader{r: response.Body}
+		var r io.Reader = received
+		if response.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(received)
+			if err != nil && err != io.EOF {
+				return truncatedBodyError{err}
+			}
+			if err == nil {
+				r = zr
+			}
+		}
+		var w io.Writer = ioutil.Discard
+		if len(opts.expectBody) > 0 {
+			s.body.Reset()
+			w = &s.body
+		}
+		if s.hash != nil {
+			s.hash.Reset()
+			w = io.MultiWriter(w, s.hash)
+		}
+		n, err := io.CopyBuffer(w, r, s.bodyBuffer)
+		s.stats.received += received.n
+		s.stats.decoded += n
+		if err != nil {
+			return truncatedBodyError{err}
+		}
+
+		if len(opts.expectStatus) > 0 && !containsInt(opts.expectStatus, response.StatusCode) {
+			return httpError{fmt.Errorf("unexpected status %s", response.Status)}
+		}
+		if len(opts.expectBody) > 0 && !bytes.Contains(s.body.Bytes(), opts.expectBody) {
+			return httpError{fmt.Errorf("response body doesn't contain '%s'", opts.expectBody)}
+		}
+		// A 304 has no body to check.
+		if s.hash != nil && response.StatusCode != http.StatusNotModified {
+			if sum := s.hash.Sum(nil); !bytes.Equal(sum, opts.verifySHA256) {
+				s.stats.mismatched++
+				return httpError{fmt.Errorf("response body has SHA-256 %x", sum)}
+			}
+		}
+		return nil
+	}
+}
+
+// Reports whether status is one the client would follow.
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// loadTestOptions holds the settings shared by every worker in a load test.
+type loadTestOptions struct {
+	transport         transportOptions
+	types             []requestType
+	targets           []*url.URL
+	host              string
+	cookies           bool
+	timePerLevel      time.Duration
+	seed              int64
+	tolerateTruncated bool
+	grpc              bool
+	websocket         bool
+	template          bool
+	seq               uint64
+	cacheBust         bool
+	scenario          bool
+	feed              *dataFeed
+	compressBody      bool
+	acceptGzip        bool
+	chunkSize         int
+	conditional       bool
+	followRedirects   bool
+	maxRedirects      int
+	// Responses must have one of these statuses and contain this body, if
+	// given, to count as a success.
+	expectStatus []int
+	expectBody   []byte
+	// The SHA-256 every response body must have, if given.
+	verifySHA256 []byte
+	// Makes the sketch each worker records latencies in.
+	newLatencySketch func() latencySketch
+	// With -rps, the rate at which requests are sent in each level,
+	// whatever the latency, the concurrency level bounding how many may be
+	// in flight at once; 0 for closed-loop workers.
+	rate float64
+	// Whether requests arrive as a Poisson process at rate, rather than at
+	// fixed intervals.
+	poisson bool
+	// How long each level runs before timePerLevel, with nothing sent
+	// meanwhile counted, so that connections are established and caches
+	// warm by the time it's measured.
+	rampup time.Duration
+	// How long each worker waits between requests, as an interactive
+	// client would.
+	thinkTime time.Duration
+	// The fraction of thinkTime that's random, drawn uniformly or, if
+	// thinkExponential, from an exponential distribution, so that workers
+	// don't fall into step with one another.
+	thinkJitter      float64
+	thinkExponential bool
+	// If set, each level runs until this many requests have been sent after
+	// rampup, instead of for timePerLevel, and its throughput is measured
+	// over however long they took.
+	requestsPerLevel int
+	// Kept up to date as requests complete, if the run is being watched.
+	live *liveStats
+	// Traces a sample of requests, if set.
+	tracer *otlpTracer
+}
+
+// workerResult is what a single load test worker reports once its time is up.
+type workerResult struct {
+	requests int
+	// Requests that succeeded in each second of the level.
+	perSecond        []int
+	requestsByType   []int
+	errors           int
+	truncated        int
+	errorsByCategory errorCounts
+	responses        responseStats
+}
+
+// The most latency sketches a level records into, however many workers it
+// has, so that the memory they take doesn't grow with concurrency. Workers
+// take turns at them, holding one's lock only to record a latency.
+const latencyShards = 16
+
+// sharedSketch is a latency sketch that several workers record into.
+type sharedSketch struct {
+	mu     sync.Mutex
+	sketch latencySketch
+}
+
+func (s *sharedSketch) record(d time.Duration) {
+	s.mu.Lock()
+	s.sketch.record(d)
+	s.mu.Unlock()
+}
+
+// levelResult is the outcome of running a single concurrency level.
+type levelResult struct {
+	concurrency int
+	// Which run of the level this was, from 0, with -replicates.
+	replicate        int
+	throughput       float64
+	throughputByType []float64
+	requests         int
+	// Requests that succeeded in each second of the level.
+	perSecond        []int
+	errors           int
+	truncated        int
+	errorsByCategory errorCounts
+	tlsHandshakes    int
+	tlsResumed       int
+	responses        responseStats
+	// When the level started, after any ramp-up, and how long it took from
+	// then until the last of the workers finished.
+	start    time.Time
+	duration time.Duration
+	// The latency of each successful request.
+	latency latencySketch
+}
+
+// Runs a single load test, returns how many requests succeeded and failed.
+// Each request is drawn from opts.types using rng, or with opts.scenario the
+// types are issued in order and a request is a completed pass through them
+// all. Requests cycle through opts.targets for their URL if any were given,
+// and are issued through transport, which is shared between workers. If
+// arrivals isn't nil, each request waits for its time on the schedule, and
+// its latency is measured from then, so that time spent queued behind
+// earlier requests counts. If budget isn't nil, it's the number of requests
+// the workers have left to send between them once rampup is over, and each
+// worker stops when there are none left rather than after timePerLevel.
+// The latencies of the requests that succeed are recorded in latencies.
+func runLoadTest(transport http.RoundTripper, opts *loadTestOptions, arrivals *arrivalSchedule, budget *int64, latencies *sharedSketch, rng *rand.Rand, wg *sync.WaitGroup, startWg *sync.WaitGroup) <-chan workerResult {
+	out := make(chan workerResult, 1)
+	s := &session{client: newClient(transport), bodyBuffer: make([]byte, 50000)}
+	if opts.cookies {
+		// Each worker is its own session, replaying the cookies it's been
+		// given.
+		s.client.Jar, _ = cookiejar.New(nil)
+	}
+	s.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !opts.followRedirects {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > opts.maxRedirects {
+			return httpError{fmt.Errorf("stopped after %d redirects", opts.maxRedirects)}
+		}
+		s.stats.redirects++
+		return nil
+	}
+	if opts.conditional {
+		s.validators = make(map[string]validators)
+	}
+	if opts.verifySHA256 != nil {
+		s.hash = sha256.New()
+	}
+
+	go func() {
+		defer wg.Done()
+		// Roughly synchronize the start of all our load test goroutines
+		startWg.Wait()
+		start := time.Now()
+		result := workerResult{requestsByType: make([]int, len(opts.types))}
+		var ws *webSocketWorker
+		if opts.websocket {
+			ws = &webSocketWorker{url: opts.types[0].url, host: opts.host, dial: newDialer(&opts.transport), tlsConfig: opts.transport.tlsConfig(nil)}
+			// Connect the way HTTP requests would, taking turns at the
+			// source addresses with the other workers and counting the
+			// TLS handshakes, unless the transport leaves either to
+			// net/http's defaults.
+			if tr, ok := transport.(*http.Transport); ok {
+				if tr.Dial != nil {
+					ws.dial = tr.Dial
+				}
+				if tr.TLSClientConfig != nil {
+					ws.tlsConfig = tr.TLSClientConfig
+				}
+			}
+			defer ws.close()
+		}
+		var e *expander
+		if opts.template {
+			e = newExpander(rng, &opts.seq, opts.feed)
+		}
+		// Workers start at different points in the target list so that
+		// they aren't all requesting the same URL at once.
+		var next int
+		if len(opts.targets) > 0 {
+			next = rng.Intn(len(opts.targets))
+		}
+		// Issues the i'th request type.
+		issue := func(i int) error {
+			t := &opts.types[i]
+			if len(opts.targets) > 0 {
+				target := *t
+				target.url = opts.targets[next%len(opts.targets)]
+				next++
+				t = &target
+			}
+			if e != nil {
+				expanded, err := e.expandRequest(t)
+				if err != nil {
+					return fmt.Errorf("expanding template: %s", err)
+				}
+				// Bodies without templates were compressed up front.
+				if opts.compressBody && len(expanded.body) > 0 {
+					expanded.body = gzipBody(expanded.body)
+				}
+				t = &expanded
+			}
+			if opts.cacheBust {
+				t = cacheBusted(t, rng)
+			}
+			switch {
+			case opts.websocket:
+				return ws.echo()
+			case opts.grpc:
+				return sendGRPCRequest(s, t, opts)
+			default:
+				return sendRequest(s, t, opts)
+			}
+		}
+		// Whether the level is past its ramp-up, so that requests sent now
+		// count.
+		measuring := opts.rampup == 0
+		// Records the outcome of issuing the i'th request type, returning
+		// whether it counts as a success.
+		record := func(i int, err error) bool {
+			if !measuring {
+				return err == nil
+			}
+			if err != nil {
+				_, truncated := err.(truncatedBodyError)
+				if !truncated || !opts.tolerateTruncated {
+					if truncated {
+						result.truncated++
+					}
+					result.errors++
+					result.errorsByCategory[categorizeError(err)]++
+					debugf("error issuing request: %s", err)
+					return false
+				}
+			}
+			result.requestsByType[i]++
+			return true
+		}
+		// When the request about to be sent was scheduled, with arrivals.
+		var scheduled time.Time
+		// Issues the i'th request type and records how it went, along with
+		// its latency if it succeeded.
+		send := func(i int) bool {
+			began := time.Now()
+			if !scheduled.IsZero() {
+				began, scheduled = scheduled, time.Time{}
+			}
+			err := issue(i)
+			latency := time.Since(began)
+			if !record(i, err) {
+				if opts.live != nil {
+					opts.live.recordError()
+				}
+				return false
+			}
+			if measuring {
+				latencies.record(latency)
+			}
+			if opts.live != nil {
+				opts.live.recordSuccess(latency)
+			}
+			return true
+		}
+
+		// Counts a request that succeeded, in total and in the second of the
+		// level it finished in.
+		succeeded := func() {
+			if !measuring {
+				return
+			}
+			result.requests++
+			second := int(time.Since(start.Add(opts.rampup)) / time.Second)
+			for len(result.perSecond) <= second {
+				result.perSecond = append(result.perSecond, 0)
+			}
+			result.perSecond[second]++
+		}
+
+		running := func() bool {
+			return budget != nil || time.Since(start) <= opts.rampup+opts.timePerLevel
+		}
+		for sent := false; running(); sent = true {
+			if sent && opts.thinkTime > 0 {
+				time.Sleep(thinkFor(opts, rng))
+				if !running() {
+					break
+				}
+			}
+			measuring = measuring || time.Since(start) >= opts.rampup
+			if measuring && budget != nil && atomic.AddInt64(budget, -1) < 0 {
+				break
+			}
+			if arrivals != nil {
+				at, ok := arrivals.take()
+				if !ok {
+					break
+				}
+				time.Sleep(time.Until(at))
+				scheduled = at
+			}
+			if opts.scenario {
+				// A scenario only completes once each of its steps has
+				// succeeded in turn.
+				completed := true
+				if e != nil {
+					e.advance()
+				}
+				for i := range opts.types {
+					if !send(i) {
+						completed = false
+						break
+					}
+				}
+				if completed {
+					succeeded()
+				}
+				continue
+			}
+			if e != nil {
+				e.advance()
+			}
+			i := pickRequestType(opts.types, rng)
+			if send(i) {
+				succeeded()
+			}
+		}
+		result.responses = s.stats
+		out <- result
+		close(out)
+	}()
+
+	return out
+}
+
+// Returns how long a worker is to wait before its next request: thinkTime,
+// with its thinkJitter fraction drawn at random using rng, keeping the same
+// mean.
+func thinkFor(opts *loadTestOptions, rng *rand.Rand) time.Duration {
+	fixed := float64(opts.thinkTime) * (1 - opts.thinkJitter)
+	random := float64(opts.thinkTime) * opts.thinkJitter
+	if opts.thinkExponential {
+		random *= rng.ExpFloat64()
+	} else {
+		random *= 2 * rng.Float64()
+	}
+	return time.Duration(fixed + random)
+}
+
+// Returns a copy of t with a random query parameter added to its URL, so
+// that caches in front of the target can't answer it.
+func cacheBusted(t *requestType, rng *rand.Rand) *requestType {
+	busted := *t
+	u := *t.url
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += "_=" + strconv.FormatUint(rng.Uint64(), 36)
+	busted.url = &u
+	return &busted
+}
+
+// returns how many requests succeeded in one second at concurrencyLevel. If
+// transport is nil, one is built with newTransport and the TLS handshakes
+// it makes are counted.
+func runLoadTests(opts *loadTestOptions, concurrencyLevel int, transport http.RoundTripper) levelResult {
+	var stats tlsStats
+	if transport == nil {
+		transport = newTransport(&opts.transport, concurrencyLevel, &stats)
+	}
+
+	var wg sync.WaitGroup
+	var startWg sync.WaitGroup
+	// a slice of channels containing throughput per goroutine
+	var requests []<-chan workerResult
+	startWg.Add(1)
+	wg.Add(concurrencyLevel)
+
+	var arrivals *arrivalSchedule
+	if opts.rate > 0 {
+		var rng *rand.Rand
+		if opts.poisson {
+			rng = rand.New(rand.NewSource(opts.seed))
+		}
+		arrivals = newArrivalSchedule(opts.rate, opts.rampup+opts.timePerLevel, rng)
+	}
+	var budget *int64
+	if opts.requestsPerLevel > 0 {
+		remaining := int64(opts.requestsPerLevel)
+		budget = &remaining
+	}
+	shards := make([]*sharedSketch, latencyShards)
+	if concurrencyLevel < latencyShards {
+		shards = shards[:concurrencyLevel]
+	}
+	for i := range shards {
+		shards[i] = &sharedSketch{sketch: opts.newLatencySketch()}
+	}
+	for i := 0; i < concurrencyLevel; i++ {
+		rng := rand.New(rand.NewSource(opts.seed + int64(i)))
+		request := runLoadTest(transport, opts, arrivals, budget, shards[i%len(shards)], rng, &wg, &startWg)
+		requests = append(requests, request)
+	}
+
+	start := time.Now()
+	if arrivals != nil {
+		arrivals.begin(start)
+	}
+	startWg.Done()
+	wg.Wait()
+	requestsPerWorker := chansToSlice(requests, concurrencyLevel)
+	result := levelResult{
+		concurrency:      concurrencyLevel,
+		throughputByType: make([]float64, len(opts.types)),
+		start:            start.Add(opts.rampup),
+		duration:         time.Since(start) - opts.rampup,
+		latency:          opts.newLatencySketch(),
+	}
+	totalRequests := 0
+	for _, requests := range requestsPerWorker {
+		totalRequests += requests.requests
+		result.errors += requests.errors
+		result.truncated += requests.truncated
+		for i, n := range requests.perSecond {
+			for len(result.perSecond) <= i {
+				result.perSecond = append(result.perSecond, 0)
+			}
+			result.perSecond[i] += n
+		}
+		result.errorsByCategory.add(requests.errorsByCategory)
+		result.responses.add(requests.responses)
+		for i, n := range requests.requestsByType {
+			result.throughputByType[i] += float64(n)
+		}
+	}
+	for _, shard := range shards {
+		result.latency.merge(shard.sketch)
+	}
+	result.requests = totalRequests
+	seconds := result.duration.Seconds()
+	result.throughput = float64(totalRequests) / seconds
+	result.tlsHandshakes = int(atomic.LoadUint64(&stats.handshakes))
+	result.tlsResumed = int(atomic.LoadUint64(&stats.resumed))
+	for i := range result.throughputByType {
+		result.throughputByType[i] /= seconds
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTransport answers requests without a network: /ok with a 200, /missing
+// with a 404, /truncated with a body that ends early, and anything else
+// with an error.
+type fakeTransport struct{}
+
+// truncatedBody returns some of a body and then io.ErrUnexpectedEOF, as
+// when a server closes the connection before sending all of it.
+type truncatedBody struct{ sent bool }
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.sent {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b.sent = true
+	return copy(p, "par"), nil
+}
+
+func (b *truncatedBody) Close() error { return nil }
+
+func (fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+		Body:       ioutil.NopCloser(strings.NewReader("ok")),
+	}
+	switch req.URL.Path {
+	case "/ok":
+	case "/missing":
+		response.StatusCode, response.Status = http.StatusNotFound, "404 Not Found"
+	case "/truncated":
+		response.Body = &truncatedBody{}
+	default:
+		return nil, errors.New("connection refused")
+	}
+	return response, nil
+}
+
+func TestRunLoadTests(t *testing.T) {
+	const requests = 50
+	for _, tc := range []struct {
+		path              string
+		expectStatus      []int
+		tolerateTruncated bool
+		succeeded         int
+		errors            int
+		truncated         int
+	}{
+		{path: "/ok", succeeded: requests},
+		{path: "/missing", succeeded: requests},
+		{path: "/missing", expectStatus: []int{200}, errors: requests},
+		{path: "/refused", errors: requests},
+		{path: "/truncated", errors: requests, truncated: requests},
+		{path: "/truncated", tolerateTruncated: true, succeeded: requests},
+	} {
+		u, _ := url.Parse("http://fake" + tc.path)
+		newSketch, _ := latencySketchMaker("hdr")
+		opts := &loadTestOptions{
+			types:             []requestType{{name: "GET " + tc.path, method: "GET", url: u, weight: 1}},
+			timePerLevel:      time.Second,
+			expectStatus:      tc.expectStatus,
+			tolerateTruncated: tc.tolerateTruncated,
+			newLatencySketch:  newSketch,
+			requestsPerLevel:  requests,
+		}
+		r := runLoadTests(opts, 4, fakeTransport{})
+		if r.requests != tc.succeeded || r.errors != tc.errors || r.truncated != tc.truncated {
+			t.Errorf("%s (expectStatus %v, tolerateTruncated %t): got %d succeeded, %d errors, %d truncated; want %d, %d, %d",
+				tc.path, tc.expectStatus, tc.tolerateTruncated, r.requests, r.errors, r.truncated, tc.succeeded, tc.errors, tc.truncated)
+		}
+		if got := r.latency.count(); got != int64(tc.succeeded) {
+			t.Errorf("%s: recorded %d latencies, want %d", tc.path, got, tc.succeeded)
+		}
+	}
+}
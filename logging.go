@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	logDebug logLevel = iota
+	logInfo
+	logWarn
+	logError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(s string) (logLevel, bool) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), true
+		}
+	}
+	return 0, false
+}
+
+// leveledLogger writes messages at or above its level to w, as text or as
+// one JSON object per line.
+type leveledLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+	json  bool
+}
+
+var logger = &leveledLogger{w: os.Stderr, level: logInfo}
+
+func (l *leveledLogger) enabled(level logLevel) bool {
+	return level >= l.level
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	now := time.Now()
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+
+	var line bytes.Buffer
+	if l.json {
+		json.NewEncoder(&line).Encode(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{now.Format(time.RFC3339Nano), level.String(), msg})
+	} else {
+		fmt.Fprintf(&line, "%s %-5s %s\n", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg)
+	}
+
+	l.mu.Lock()
+	l.w.Write(line.Bytes())
+	l.mu.Unlock()
+}
+
+func debugf(format string, args ...interface{}) { logger.logf(logDebug, format, args...) }
+func infof(format string, args ...interface{})  { logger.logf(logInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logger.logf(logWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logger.logf(logError, format, args...) }
+
+// Lets the standard logger, used for fatal errors, write through logger at
+// the error level.
+type errorLogWriter struct{}
+
+func (errorLogWriter) Write(p []byte) (int, error) {
+	logger.logf(logError, "%s", p)
+	return len(p), nil
+}
+
+// Adds the flags that configure logging, which every command takes.
+func addLogFlags(flags *flag.FlagSet) {
+	flags.String("logLevel", "info", "least severe messages to log: debug, info, warn or error")
+	flags.String("logFormat", "text", "how to log messages: text or json")
+}
+
+// Parses args into flags, then configures logging from the flags added by
+// addLogFlags.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	flags.Parse(args)
+
+	l, ok := parseLogLevel(flags.Lookup("logLevel").Value.String())
+	if !ok {
+		exUsage("-logLevel must be debug, info, warn or error")
+	}
+	format := flags.Lookup("logFormat").Value.String()
+	switch format {
+	case "text", "json":
+	default:
+		exUsage("-logFormat must be text or json")
+	}
+	logger.level = l
+	logger.json = format == "json"
+	log.SetFlags(0)
+	log.SetOutput(errorLogWriter{})
+}
@@ -1,25 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/optimize"
 )
 
 // `http-max-rps` is designed to tell you the maximum rps that
@@ -29,267 +33,1456 @@ import (
 // Thanks to @brendantracey for the go playground snippet least squared regression
 // code that I borrowed verbatim.
 func main() {
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runCommand(args)
+	case "fit":
+		fitCommand(args)
+	case "predict":
+		predictCommand(args)
+	case "plot":
+		plotCommand(args)
+	case "compare":
+		compareCommand(args)
+	case "help":
+		printUsage()
+	default:
+		exUsage("unknown command: %s", command)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [command] [flags]
+
+Commands:
+  run      generate load at each concurrency level and fit the model (default)
+  fit      fit the model to measurements saved as CSV
+  predict  predict throughput from a saved model
+  plot     plot saved measurements and models in the terminal
+  compare  compare a run with a baseline
+
+Run '%s <command> -help' for the flags of each command.
+`, path.Base(os.Args[0]), path.Base(os.Args[0]))
+}
+
+// Returns a flag set for a command. args describes any positional
+// arguments the command takes.
+func newFlagSet(command, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]%s\n", path.Base(os.Args[0]), command, args)
+		flags.PrintDefaults()
+	}
+	addLogFlags(flags)
+	return flags
+}
+
+// Generates load at each concurrency level and fits the model to the
+// throughput achieved.
+func runCommand(args []string) {
+	flags := newFlagSet("run", "")
 	var (
-		address           = flag.String("address", "http://localhost:4140", "URL of http server or intermediary")
-		host              = flag.String("host", "", "value of Host header to set")
-		concurrencyLevels = flag.String("concurrencyLevels", "1,5,10,20,30", "levels of concurrency to test with")
-		timePerLevel      = flag.Duration("timePerLevel", 1*time.Second, "how much time to spend testing each concurrency level")
-		debug             = flag.Bool("debug", false, "print out some extra information for debugging")
+		address           = flags.String("address", "http://localhost:4140", "URL of http server or intermediary, or unix:///path/to.sock for a unix domain socket")
+		host              = flags.String("host", "", "value of Host header to set")
+		concurrencyLevels = flags.String("concurrencyLevels", "1,5,10,20,30", "levels of concurrency to test with, as a comma-separated list of levels and ranges such as 1..100:10")
+		autoLevels        = flags.Bool("autoLevels", false, "choose the concurrency levels to test instead: double from 1 until throughput stops improving, then bisect around the peak")
+		timePerLevel      = flags.Duration("timePerLevel", 1*time.Second, "how much time to spend testing each concurrency level; may be under a second")
+		totalDuration     = flags.Duration("totalDuration", 0, "how long the whole run may take, divided between the levels instead of giving each -timePerLevel; 0 disables")
+		durationWeighting = flags.String("durationWeighting", "equal", "with -totalDuration, how to divide it: equal, giving every level the same time, or concurrency, in proportion to each level's concurrency")
+		requestsPerLevel  = flags.Int("requestsPerLevel", 0, "send this many requests at each concurrency level instead of running for -timePerLevel, measuring how long they take; 0 disables")
+		stopBelowPeak     = flags.Float64("stopBelowPeak", 0, "stop once throughput at two levels in a row is this fraction or more below the peak so far, such as 0.1; 0 disables. Can't be used with -autoLevels")
+		rerunAnomalies    = flags.Bool("rerunAnomalies", false, "run a level again once if its throughput dips well below both neighbours' or varies by more than 25% from second to second, keeping the better run")
+		rps               = flags.Float64("rps", 0, "send requests at this fixed rate in each level, open-loop, whatever their latency, with the concurrency level bounding how many are in flight; 0 for closed-loop workers")
+		arrival           = flags.String("arrival", "constant", "with -rps, how requests arrive: constant, at fixed intervals, or poisson, at random, exponentially distributed ones")
+		replicates        = flags.Int("replicates", 1, "times to run each concurrency level, all of which are fitted to")
+		warmup            = flags.Duration("warmup", 0, "how long to generate load before the first level, without measuring it, to warm up the target")
+		warmupLevel       = flags.Int("warmupConcurrency", 0, "concurrency to -warmup at (default: the median of -concurrencyLevels)")
+		thinkTime         = flags.Duration("thinkTime", 0, "how long each worker waits between requests, to model interactive clients")
+		thinkJitter       = flags.Float64("thinkJitter", 0, "fraction of -thinkTime to randomize, from 0 to 1, keeping its mean, so that workers don't synchronize")
+		thinkDistribution = flags.String("thinkDistribution", "uniform", "how the -thinkJitter fraction of -thinkTime is distributed: uniform or exponential")
+		rampup            = flags.Duration("rampup", 0, "how long to run each level before timePerLevel without counting anything, so that connections are established and caches warm")
+		cooldown          = flags.Duration("cooldown", 0, "how long to idle between concurrency levels to let the server recover")
+		method            = flags.String("method", "GET", "HTTP method to use")
+		body              = flags.String("body", "", "body to send with each request")
+		bodyFile          = flags.String("bodyFile", "", "file containing the body to send with each request, read once at startup")
+		contentType       = flags.String("contentType", "", "value of Content-Type header to set")
+		basicAuth         = flags.String("basicAuth", "", "credentials to send with each request using basic auth, as user:password")
+		bearerToken       = flags.String("bearerToken", "", "token to send with each request as an Authorization: Bearer header")
+		cacheBust         = flags.Bool("cacheBust", false, "add a random query parameter to each request so caches in front of the target can't answer it")
+		expectStatus      = flags.String("expectStatus", "", "comma separated response statuses to count as a success, e.g. 200,204; others count as errors")
+		expectBody        = flags.String("expectBodyContains", "", "text each response body must contain to count as a success")
+		verifySHA256      = flags.String("verifySha256", "", "hex SHA-256 every response body must have; mismatches count as errors and are reported")
+		followRedirects   = flags.Bool("followRedirects", true, "follow redirects, rather than counting the redirect itself as the response")
+		maxRedirects      = flags.Int("maxRedirects", 10, "redirects to follow for a single request before it counts as an error")
+		conditional       = flags.Bool("conditional", false, "revalidate each URL with If-None-Match or If-Modified-Since once it has returned an ETag or Last-Modified, reporting how often it's not modified")
+		acceptGzip        = flags.Bool("acceptGzip", false, "ask for gzip compressed responses and report the bytes received before and after decompression")
+		chunkSize         = flags.Int("chunkSize", 0, "send request bodies with chunked transfer encoding, in chunks of this many bytes")
+		compressBody      = flags.Bool("compressBody", false, "gzip request bodies and send them with Content-Encoding: gzip")
+		useTemplate       = flags.Bool("template", false, "expand {{uuid}}, {{seq}} and {{randint a b}} in the URL, headers and body of each request, escaping what's expanded in the URL's path and query")
+		dataFeedFile      = flags.String("dataFeed", "", "CSV file whose columns, named by its first row, are template variables such as {{.user}}, one row per request (implies -template)")
+		cookies           = flags.Bool("cookies", false, "give each worker a cookie jar, replaying cookies set by earlier responses")
+		targets           = flags.String("targets", "", "file of URLs, one per line, that each worker cycles through")
+		head              = flags.Bool("head", false, "make HEAD requests, fetching only the headers of each response")
+		mix               = flags.String("mix", "", "weighted mix of requests to issue, e.g. 'GET /read:90,POST /write:10' or '/api=80,/health=20'")
+		scenario          = flags.String("scenario", "", "steps each worker issues in order, counting completed passes, e.g. 'POST /login,GET /fetch,POST /post'")
+		seed              = flags.Int64("seed", 0, "seed for randomized request selection (default: current time)")
+		tolerateTruncated = flags.Bool("tolerateTruncated", false, "count responses with truncated bodies as successes instead of errors")
+		http2             = flags.Bool("http2", false, "speak only HTTP/2: h2 for https targets, h2c with prior knowledge otherwise")
+		grpc              = flags.String("grpc", "", "make unary gRPC calls to this method, e.g. /grpc.health.v1.Health/Check, instead of HTTP requests, with -body or -bodyFile as the serialized request message, empty if neither is given")
+		websocket         = flags.Bool("websocket", false, "measure message echoes over a WebSocket connection per worker instead of HTTP requests")
+		certFile          = flags.String("cert", "", "file containing a PEM encoded client certificate to present over TLS")
+		keyFile           = flags.String("key", "", "file containing the PEM encoded private key for -cert")
+		caFile            = flags.String("caFile", "", "file containing PEM encoded CA certificates to verify the target against (default: the host's root CAs)")
+		insecure          = flags.Bool("insecure", false, "skip verification of the target's TLS certificate")
+		sni               = flags.String("sni", "", "TLS server name to send, independent of the Host header (default: the address's host)")
+		tlsMinVersion     = flags.String("tlsMinVersion", "", "minimum TLS version to offer: 1.0, 1.1, 1.2 or 1.3")
+		tlsMaxVersion     = flags.String("tlsMaxVersion", "", "maximum TLS version to offer: 1.0, 1.1, 1.2 or 1.3")
+		tlsCipherSuites   = flags.String("tlsCipherSuites", "", "comma-separated cipher suites to offer for TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+		tlsSessionTickets = flags.Bool("tlsSessionTickets", false, "resume TLS sessions with session tickets, and report how many handshakes were resumed")
+		noreuse           = flags.Bool("noreuse", false, "disable keep-alives, making a new connection for every request")
+		socks5            = flags.String("socks5", "", "send requests through the SOCKS5 proxy at [user:password@]host:port")
+		proxyUrl          = flags.String("proxyUrl", "", "send requests through the HTTP proxy at this URL instead of any set in the environment")
+		noProxyEnv        = flags.Bool("noProxyEnv", false, "ignore HTTP_PROXY, HTTPS_PROXY and NO_PROXY in the environment")
+		resolveOnce       = flags.Bool("resolveOnce", false, "resolve the target's hostname once and connect to that address throughout")
+		dnsServer         = flags.String("dnsServer", "", "resolve hostnames using the DNS server at host[:port] instead of the host's resolver")
+		ipv4              = flags.Bool("4", false, "connect to the target over IPv4 only")
+		ipv6              = flags.Bool("6", false, "connect to the target over IPv6 only")
+		sourceIps         = flags.String("sourceIps", "", "comma-separated local IPs to make connections from, in turn")
+		outputFormat      = flags.String("outputFormat", "text", "format to report results in: text, json or markdown")
+		metricsAddr       = flags.String("metricsAddr", "", "address to serve Prometheus metrics on at /metrics while the run is in progress, e.g. :9090")
+		statsdAddr        = flags.String("statsd", "", "host:port of a StatsD server to stream request counts, errors and latencies to while the run is in progress")
+		statsdPrefix      = flags.String("statsdPrefix", "http_max_rps", "prefix of the metrics sent to StatsD")
+		statsdSampleRate  = flags.Float64("statsdSampleRate", 0.1, "fraction of request latencies to send to StatsD")
+		influxDest        = flags.String("influx", "", "file, or URL of an InfluxDB write endpoint such as http://localhost:8086/write?db=perf, to save per-second samples to in line protocol")
+		otlpEndpoint      = flags.String("otlpEndpoint", "", "base URL of an OTLP/HTTP collector, e.g. http://localhost:4318, to export the results and traced requests to")
+		otlpSampleRate    = flags.Float64("otlpTraceSampleRate", 0, "fraction of requests to trace with a client span exported to -otlpEndpoint")
+		pushgateway       = flags.String("pushgateway", "", "URL of a Prometheus Pushgateway to push the fitted model and throughput at each level to")
+		pushJob           = flags.String("pushJob", "http-max-rps", "job label to push results under")
+		pushInstance      = flags.String("pushInstance", "", "instance label to push results under")
+		outFile           = flags.String("out", "", "file to save the results to as JSON, along with every flag's value and when the run started")
+		fitLatency        = flags.Bool("fitLatency", false, "also fit the model to the mean latency at each level and warn if it disagrees with the throughput fit")
+		bootstrap         = flags.Int("bootstrap", 0, "resamples of the measurements to refit the model to, to give 95% confidence intervals for it; 0 disables")
+		baselineFile      = flags.String("baseline", "", "results of an earlier run, saved with -out or -outputFormat json, to compare this one with, exiting with status 3 if it regressed")
+		tolerance         = flags.Float64("tolerance", 0.05, "fraction by which throughput may fall below -baseline before it's marked as regressed")
+		dbFile            = flags.String("db", "", "SQLite database to append the run to, with the flags used, the fitted model and each level's measurements; needs the sqlite3 command, which the Docker image includes")
+		htmlReport        = flags.String("htmlReport", "", "file to save a self-contained HTML report of the run to")
+		minMaxRps         = flags.Float64("minMaxRps", 0, "exit with status 3 if the fitted maxRps is below this")
+		failIfMaxRpsBelow = flags.String("failIfMaxRpsBelow", "", "exit with status 3 if the fitted maxRps is below this, either a throughput or a percentage of -baseline's maxRps, such as 95%")
+		maxErrorRate      = flags.Float64("maxErrorRate", -1, "exit with status 3 if more than this fraction of requests failed, from 0 to 1")
+		junitFile         = flags.String("junit", "", "file to save a JUnit XML report to, with a test case for each level and for each of -minMaxRps, -failIfMaxRpsBelow and -maxErrorRate that's set")
+		quiet             = flags.Bool("quiet", false, "print only the result: the report with -outputFormat json or markdown, or the fitted model as key=value lines")
+		progress          = flags.Bool("progress", true, "show how each level is going on a line of stderr, if it's a terminal")
+		plotFile          = flags.String("plot", "", "file ending in .svg to save an SVG plot of the measurements and fitted model to")
+		latencySketchKind = flags.String("latencySketch", "hdr", "how to summarize latencies: hdr for an HDR-style histogram, or tdigest for a t-digest, which is smaller and is saved in the JSON report so that runs can be merged")
+		histogramFile     = flags.String("histogramFile", "", "file to save the latency histogram of each level to, in HdrHistogram's log format")
+		csvFile           = flags.String("csv", "", "file to save the concurrency, throughput, errors and duration of each level to, for use with fit and plot")
+		modelFile         = flags.String("model", "", "file to save the fitted model to, for use with predict and plot")
+		fitOpts           = addFitFlags(flags)
 	)
+	var headerFlags stringsFlag
+	flags.Var(&headerFlags, "H", "header to send with each request, as 'Name: value' (repeatable)")
+	var formFlags stringsFlag
+	flags.Var(&formFlags, "form", "multipart/form-data field to send, as name=value or name=@file to upload a file (repeatable, implies -method POST)")
+	var resolveFlags stringsFlag
+	flags.Var(&resolveFlags, "resolve", "connect to addr for requests to host:port, given as host:port:addr (repeatable)")
+	usage := flags.Usage
+	flags.Usage = func() {
+		printUsage()
+		fmt.Fprintln(os.Stderr)
+		usage()
+	}
+
+	parseFlags(flags, args)
+	fitOpts.validate()
+
+	var baseline *runReport
+	if *baselineFile != "" {
+		b, err := readRunReport(*baselineFile)
+		if err != nil {
+			log.Fatalf("could not read baseline: %s", err)
+		}
+		baseline = &b
+	}
+	sla := slaThresholds{minMaxRps: *minMaxRps, maxErrorRate: *maxErrorRate}
+	if *failIfMaxRpsBelow != "" {
+		threshold, err := parseMaxRpsThreshold(*failIfMaxRpsBelow, baseline)
+		if err != nil {
+			exUsage("invalid -failIfMaxRpsBelow: '%s': %s", *failIfMaxRpsBelow, err.Error())
+		}
+		sla.failIfMaxRpsBelow, sla.failIfMaxRpsBelowFlag = threshold, *failIfMaxRpsBelow
+	}
+
+	if *timePerLevel <= 0 {
+		exUsage("-timePerLevel must be positive")
+	}
+	if *dbFile != "" {
+		if err := checkSQLite(); err != nil {
+			exUsage("-db needs the sqlite3 command: %s", err)
+		}
+	}
+
+	levels, err := parseLevels(*concurrencyLevels)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *autoLevels {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "concurrencyLevels" {
+				exUsage("-autoLevels and -concurrencyLevels can't be used together")
+			}
+		})
+		// chooseLevels stops doubling as soon as throughput stops
+		// improving, long before it could fall below the peak.
+		if *stopBelowPeak != 0 {
+			exUsage("-autoLevels and -stopBelowPeak can't be used together")
+		}
+	}
+
+	destURL, err := url.Parse(*address)
+	if err != nil {
+		exUsage("invalid URL: '%s': %s\n", *address, err.Error())
+	}
+
+	// Requests to a unix domain socket are made as if to http://localhost/,
+	// with the transport dialing the socket instead.
+	var unixSocket string
+	if destURL.Scheme == "unix" {
+		unixSocket = destURL.Path
+		destURL = &url.URL{Scheme: "http", Host: "localhost", Path: "/"}
+	}
+
+	switch *outputFormat {
+	case "text", "json", "markdown":
+	default:
+		exUsage("unknown -outputFormat: '%s': expected text, json or markdown", *outputFormat)
+	}
+	newLatencySketch, ok := latencySketchMaker(*latencySketchKind)
+	if !ok {
+		exUsage("unknown -latencySketch: '%s': expected hdr or tdigest", *latencySketchKind)
+	}
+	if *histogramFile != "" && *latencySketchKind != "hdr" {
+		exUsage("-histogramFile needs -latencySketch hdr")
+	}
+	if *maxErrorRate > 1 {
+		exUsage("-maxErrorRate must be at most 1")
+	}
+
+	methodSet := false
+	flags.Visit(func(f *flag.Flag) {
+		methodSet = methodSet || f.Name == "method"
+	})
+	if *head {
+		if methodSet || len(formFlags) > 0 || *body != "" || *bodyFile != "" {
+			exUsage("-head cannot be used with -method, -form, -body or -bodyFile")
+		}
+		*method = "HEAD"
+	}
+	if len(formFlags) > 0 && !methodSet {
+		// Like curl, uploading a form implies POST unless told otherwise.
+		*method = "POST"
+	}
+	*method = strings.ToUpper(*method)
+	types := []requestType{{name: *method + " " + destURL.Path, method: *method, url: destURL, weight: 1}}
+	if *mix != "" {
+		types, err = parseMix(*mix, destURL, *method)
+		if err != nil {
+			exUsage("invalid mix: '%s': %s\n", *mix, err.Error())
+		}
+	}
+
+	if *scenario != "" {
+		if *mix != "" {
+			exUsage("-scenario and -mix cannot be used together")
+		}
+		types, err = parseScenario(*scenario, destURL, *method)
+		if err != nil {
+			exUsage("invalid scenario: '%s': %s\n", *scenario, err.Error())
+		}
+	}
+
+	if *grpc != "" {
+		if *mix != "" || *scenario != "" {
+			exUsage("-grpc cannot be used with -mix or -scenario")
+		}
+		// Every call is a POST of a protobuf message, not a form.
+		if methodSet || *head || len(formFlags) > 0 || *chunkSize > 0 {
+			exUsage("-grpc cannot be used with -method, -head, -form or -chunkSize")
+		}
+		method, err := url.Parse(*grpc)
+		if err != nil {
+			exUsage("invalid gRPC method: '%s': %s\n", *grpc, err.Error())
+		}
+		types = []requestType{{name: "gRPC " + *grpc, method: "POST", url: destURL.ResolveReference(method), weight: 1}}
+		// gRPC is only spoken over HTTP/2.
+		*http2 = true
+	}
+
+	if *websocket && (*mix != "" || *scenario != "" || *grpc != "") {
+		exUsage("-websocket cannot be used with -mix, -scenario or -grpc")
+	}
+	// WebSocket connections are dialed directly rather than by the HTTP
+	// transport, so they can't be tunneled through a proxy.
+	if *websocket && (*socks5 != "" || *proxyUrl != "") {
+		exUsage("-websocket cannot be used with -socks5 or -proxyUrl")
+	}
+	if *plotFile != "" && !isSVG(*plotFile) {
+		exUsage("plots can only be saved as SVG, to a file ending in .svg: '%s'", *plotFile)
+	}
+
+	if *cacheBust && (*grpc != "" || *websocket) {
+		exUsage("-cacheBust cannot be used with -grpc or -websocket")
+	}
+
+	var targetURLs []*url.URL
+	if *targets != "" {
+		if *mix != "" || *scenario != "" || *grpc != "" || *websocket {
+			exUsage("-targets cannot be used with -mix, -scenario, -grpc or -websocket")
+		}
+		targetURLs, err = readTargets(*targets, destURL)
+		if err != nil {
+			exUsage("invalid targets: %s", err.Error())
+		}
+		types[0].name = *method + " " + *targets
+	}
+
+	requestBody := []byte(*body)
+	if *bodyFile != "" {
+		if *body != "" {
+			exUsage("-body and -bodyFile cannot be used together")
+		}
+		requestBody, err = ioutil.ReadFile(*bodyFile)
+		if err != nil {
+			exUsage("invalid body file: %s", err.Error())
+		}
+	}
+	if len(formFlags) > 0 {
+		if *body != "" || *bodyFile != "" || *contentType != "" {
+			exUsage("-form cannot be used with -body, -bodyFile or -contentType")
+		}
+		requestBody, *contentType, err = buildForm(formFlags)
+		if err != nil {
+			exUsage("invalid form: %s", err.Error())
+		}
+	}
+	header := make(http.Header)
+	if *contentType != "" {
+		header.Set("Content-Type", *contentType)
+	}
+	switch {
+	case *basicAuth != "" && *bearerToken != "":
+		exUsage("-basicAuth and -bearerToken cannot be used together")
+	case *basicAuth != "":
+		if !strings.Contains(*basicAuth, ":") {
+			exUsage("invalid -basicAuth: expected user:password")
+		}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*basicAuth)))
+	case *bearerToken != "":
+		header.Set("Authorization", "Bearer "+*bearerToken)
+	}
+	for _, h := range headerFlags {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			exUsage("invalid header: '%s': expected 'Name: value'", h)
+		}
+		name, value := strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:])
+		// net/http takes the Host header from the request, not its headers.
+		if http.CanonicalHeaderKey(name) == "Host" {
+			if *host == "" {
+				*host = value
+			}
+			continue
+		}
+		header.Add(name, value)
+	}
+	for i := range types {
+		types[i].header = header
+		types[i].body = requestBody
+	}
+
+	var feed *dataFeed
+	if *dataFeedFile != "" {
+		feed, err = readDataFeed(*dataFeedFile)
+		if err != nil {
+			exUsage("invalid data feed: %s", err.Error())
+		}
+		// The feed's columns are only of use as template variables.
+		*useTemplate = true
+	}
+
+	if *useTemplate {
+		var row map[string]string
+		if feed != nil {
+			row = feed.rows[0]
+		}
+		check := []string{string(requestBody)}
+		for _, t := range types {
+			check = append(check, t.url.Path, t.url.RawQuery)
+		}
+		for _, u := range targetURLs {
+			check = append(check, u.Path, u.RawQuery)
+		}
+		for _, values := range header {
+			check = append(check, values...)
+		}
+		for _, s := range check {
+			if err := checkTemplate(s, row); err != nil {
+				exUsage("invalid template: %s", err.Error())
+			}
+		}
+	}
+
+	var expectedStatuses []int
+	if *expectStatus != "" {
+		for _, code := range strings.Split(*expectStatus, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil || status < 100 || status > 999 {
+				exUsage("invalid -expectStatus: '%s'", code)
+			}
+			expectedStatuses = append(expectedStatuses, status)
+		}
+	}
+
+	var checksum []byte
+	if *verifySHA256 != "" {
+		checksum, err = hex.DecodeString(*verifySHA256)
+		if err != nil || len(checksum) != sha256.Size {
+			exUsage("invalid -verifySha256: expected 64 hex digits")
+		}
+	}
+
+	if *chunkSize < 0 {
+		exUsage("-chunkSize must not be negative")
+	}
+
+	if *compressBody {
+		if len(requestBody) == 0 {
+			exUsage("-compressBody needs a request body to compress")
+		}
+		// gRPC compresses each message, saying so in its prefix, rather
+		// than the request as a whole.
+		if *grpc != "" {
+			header.Set("Grpc-Encoding", "gzip")
+		} else {
+			header.Set("Content-Encoding", "gzip")
+		}
+		// Templated bodies are compressed as each request is expanded.
+		if !*useTemplate {
+			for i := range types {
+				types[i].body = gzipBody(types[i].body)
+			}
+		}
+	}
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", path.Base(os.Args[0]))
-		flag.PrintDefaults()
+	var certificates []tls.Certificate
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			exUsage("invalid client certificate: %s", err.Error())
+		}
+		certificates = append(certificates, cert)
 	}
 
-	flag.Parse()
+	var rootCAs *x509.CertPool
+	if *caFile != "" {
+		pem, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			exUsage("invalid CA file: %s", err.Error())
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			exUsage("invalid CA file: no certificates found in %s", *caFile)
+		}
+	}
 
-	if *timePerLevel < time.Second {
-		log.Fatalf("timePerLevel cannot be less than 1 second.")
+	minTLSVersion, err := parseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		exUsage("invalid -tlsMinVersion: %s", err.Error())
+	}
+	maxTLSVersion, err := parseTLSVersion(*tlsMaxVersion)
+	if err != nil {
+		exUsage("invalid -tlsMaxVersion: %s", err.Error())
+	}
+	cipherSuites, err := parseCipherSuites(*tlsCipherSuites)
+	if err != nil {
+		exUsage("invalid -tlsCipherSuites: %s", err.Error())
+	}
+
+	var proxyURL *url.URL
+	switch {
+	case *socks5 != "" && *proxyUrl != "":
+		exUsage("-socks5 and -proxyUrl cannot be used together")
+	case *socks5 != "":
+		proxyURL, err = url.Parse("socks5://" + *socks5)
+		if err != nil {
+			exUsage("invalid SOCKS5 proxy: '%s': %s", *socks5, err.Error())
+		}
+	case *proxyUrl != "":
+		proxyURL, err = url.Parse(*proxyUrl)
+		if err != nil {
+			exUsage("invalid proxy URL: '%s': %s", *proxyUrl, err.Error())
+		}
+	}
+
+	network, ipNetwork := "", "ip"
+	switch {
+	case *ipv4 && *ipv6:
+		exUsage("-4 and -6 cannot be used together")
+	case *ipv4:
+		network, ipNetwork = "tcp4", "ip4"
+	case *ipv6:
+		network, ipNetwork = "tcp6", "ip6"
+	}
+
+	var sourceIPs []net.IP
+	if *sourceIps != "" {
+		for _, s := range strings.Split(*sourceIps, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				exUsage("invalid source IP: %s", s)
+			}
+			sourceIPs = append(sourceIPs, ip)
+		}
+	}
+
+	var resolver *net.Resolver
+	if *dnsServer != "" {
+		server := *dnsServer
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	resolve := make(map[string]string)
+	for _, r := range resolveFlags {
+		parts := strings.SplitN(r, ":", 3)
+		if len(parts) != 3 {
+			exUsage("invalid -resolve: '%s': expected host:port:addr", r)
+		}
+		addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		resolve[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(addr, parts[1])
+	}
+	if _, ok := resolve[hostPort(destURL)]; !ok && *resolveOnce && net.ParseIP(destURL.Hostname()) == nil {
+		r := resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		ips, err := r.LookupIP(context.Background(), ipNetwork, destURL.Hostname())
+		if err != nil {
+			log.Fatalf("could not resolve %s: %s", destURL.Hostname(), err)
+		}
+		_, port, _ := net.SplitHostPort(hostPort(destURL))
+		resolve[hostPort(destURL)] = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	opts := &loadTestOptions{
+		transport: transportOptions{
+			noreuse:        *noreuse,
+			sessionTickets: *tlsSessionTickets,
+			http2:          *http2,
+			proxyURL:       proxyURL,
+			noProxyEnv:     *noProxyEnv,
+			network:        network,
+			sourceIPs:      sourceIPs,
+			resolver:       resolver,
+			resolve:        resolve,
+			unixSocket:     unixSocket,
+			serverName:     *sni,
+			certificates:   certificates,
+			rootCAs:        rootCAs,
+			insecure:       *insecure,
+			minTLSVersion:  minTLSVersion,
+			maxTLSVersion:  maxTLSVersion,
+			cipherSuites:   cipherSuites,
+		},
+		types:             types,
+		targets:           targetURLs,
+		host:              *host,
+		cookies:           *cookies,
+		timePerLevel:      *timePerLevel,
+		rampup:            *rampup,
+		thinkTime:         *thinkTime,
+		requestsPerLevel:  *requestsPerLevel,
+		thinkJitter:       *thinkJitter,
+		thinkExponential:  *thinkDistribution == "exponential",
+		seed:              *seed,
+		tolerateTruncated: *tolerateTruncated,
+		grpc:              *grpc != "",
+		websocket:         *websocket,
+		template:          *useTemplate,
+		cacheBust:         *cacheBust,
+		scenario:          *scenario != "",
+		feed:              feed,
+		compressBody:      *compressBody,
+		acceptGzip:        *acceptGzip,
+		chunkSize:         *chunkSize,
+		conditional:       *conditional,
+		followRedirects:   *followRedirects,
+		maxRedirects:      *maxRedirects,
+		expectStatus:      expectedStatuses,
+		expectBody:        []byte(*expectBody),
+		verifySHA256:      checksum,
+		newLatencySketch:  newLatencySketch,
+		rate:              *rps,
+		poisson:           *arrival == "poisson",
 	}
 
-	levels := strings.Split(*concurrencyLevels, ",")
 	var denseLatency [](float64)
+	// With JSON or markdown output, stdout is kept for the report and
+	// everything else goes to stderr.
+	var out io.Writer = os.Stdout
+	if *outputFormat != "text" {
+		out = os.Stderr
+	}
+	if *quiet {
+		out = ioutil.Discard
+	}
+	showProgress := *progress && !*quiet && isTerminal(os.Stderr)
+	if *metricsAddr != "" || *statsdAddr != "" || *influxDest != "" || showProgress {
+		opts.live = &liveStats{}
+	}
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr, opts.live)
+	}
+	if *statsdAddr != "" {
+		if *statsdSampleRate <= 0 || *statsdSampleRate > 1 {
+			exUsage("-statsdSampleRate must be greater than 0 and at most 1")
+		}
+		opts.live.statsd, err = newStatsdClient(*statsdAddr, *statsdPrefix, *statsdSampleRate, opts.live)
+		if err != nil {
+			exUsage("invalid -statsd: %s", err.Error())
+		}
+	}
+
+	if *otlpSampleRate < 0 || *otlpSampleRate > 1 {
+		exUsage("-otlpTraceSampleRate must be between 0 and 1")
+	}
+	if *otlpSampleRate > 0 {
+		if *otlpEndpoint == "" {
+			exUsage("-otlpTraceSampleRate needs an -otlpEndpoint")
+		}
+		opts.tracer = newOTLPTracer(*otlpEndpoint, *otlpSampleRate)
+	}
 
-	for _, l := range levels {
-		level, err := strconv.Atoi(l)
+	var influx *influxRecorder
+	if *influxDest != "" {
+		influx = newInfluxRecorder(opts.live)
+	}
+
+	if *replicates < 1 {
+		exUsage("-replicates must be at least 1")
+	}
+	if *warmup < 0 {
+		exUsage("-warmup must not be negative")
+	}
+	if *warmupLevel < 0 {
+		exUsage("-warmupConcurrency must not be negative")
+	}
+	if *thinkTime < 0 {
+		exUsage("-thinkTime must not be negative")
+	}
+	if *thinkTime > 0 && *rps > 0 {
+		exUsage("-thinkTime can't be used with -rps, which sends requests on a schedule")
+	}
+	if *requestsPerLevel < 0 {
+		exUsage("-requestsPerLevel must not be negative")
+	}
+	if *requestsPerLevel > 0 && *rps > 0 {
+		exUsage("-requestsPerLevel can't be used with -rps, which runs each level for -timePerLevel")
+	}
+	if *thinkJitter < 0 || *thinkJitter > 1 {
+		exUsage("-thinkJitter must be between 0 and 1")
+	}
+	switch *thinkDistribution {
+	case "uniform", "exponential":
+	default:
+		exUsage("-thinkDistribution must be uniform or exponential")
+	}
+	if *rampup < 0 {
+		exUsage("-rampup must not be negative")
+	}
+	if *rps < 0 {
+		exUsage("-rps must not be negative")
+	}
+	if *rps > 0 && (*minMaxRps > 0 || *failIfMaxRpsBelow != "") {
+		exUsage("-minMaxRps and -failIfMaxRpsBelow can't be used with -rps, as open-loop throughput is just the rate requests are sent at")
+	}
+	switch *arrival {
+	case "constant", "poisson":
+	default:
+		exUsage("-arrival must be constant or poisson")
+	}
+	if *stopBelowPeak < 0 || *stopBelowPeak >= 1 {
+		exUsage("-stopBelowPeak must be at least 0 and less than 1")
+	}
+	if *totalDuration < 0 {
+		exUsage("-totalDuration must not be negative")
+	}
+	switch *durationWeighting {
+	case "equal", "concurrency":
+	default:
+		exUsage("-durationWeighting must be equal or concurrency")
+	}
+	// With -totalDuration, how long to measure each level for.
+	var levelDurations map[int]time.Duration
+	if *totalDuration > 0 {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "timePerLevel" {
+				exUsage("-totalDuration and -timePerLevel can't be used together")
+			}
+		})
+		if *autoLevels || *requestsPerLevel > 0 {
+			exUsage("-totalDuration can't be used with -autoLevels or -requestsPerLevel, which decide how long the run takes")
+		}
+		levelDurations, err = splitDuration(*totalDuration, levels, *replicates, *warmup, *rampup, *cooldown, *durationWeighting == "concurrency")
 		if err != nil {
-			log.Fatalf("unknown concurrency level: %s, %s", l, err)
+			exUsage("-totalDuration is too short: %s", err)
+		}
+		split := make([]string, len(levels))
+		for i, level := range levels {
+			split[i] = fmt.Sprintf("%d for %s", level, levelDurations[level])
+		}
+		infof("time per level: %s", strings.Join(split, ", "))
+	}
+
+	if *warmup > 0 {
+		level := *warmupLevel
+		if level == 0 {
+			sorted := append([]int(nil), levels...)
+			sort.Ints(sorted)
+			level = sorted[len(sorted)/2]
+		}
+		infof("warming up for %s at concurrency %d", *warmup, level)
+		warm := *opts
+		warm.timePerLevel, warm.rampup, warm.requestsPerLevel = *warmup, 0, 0
+		warm.live, warm.tracer = nil, nil
+		runLoadTests(&warm, level, nil)
+	}
+
+	var results []levelResult
+	runStart := time.Now()
+
+	// Runs a single level once, printing what was measured.
+	runReplicate := func(level int) levelResult {
+		if len(results) > 0 && *cooldown > 0 {
+			time.Sleep(*cooldown)
+		}
+		if levelDurations != nil {
+			opts.timePerLevel = levelDurations[level]
+		}
+
+		if opts.live != nil {
+			opts.live.startLevel(level)
+		}
+		var line *progressLine
+		if showProgress {
+			line = startProgress(os.Stderr, opts.live, level)
+		}
+		result := runLoadTests(opts, level, nil)
+		if line != nil {
+			line.finish(result)
+		}
+		if opts.live != nil {
+			opts.live.finishLevel(result)
+		}
+		debugf("concurrency %d: throughput %.1f", level, result.throughput)
+		if result.latency.count() > 0 {
+			h := result.latency
+			fmt.Fprintf(out, "concurrency %d: latency p50 %s, p90 %s, p99 %s, p99.9 %s\n", level,
+				h.valueAtPercentile(50), h.valueAtPercentile(90), h.valueAtPercentile(99), h.valueAtPercentile(99.9))
+			fmt.Fprintf(out, "concurrency %d: latency mean %s, stddev %s, min %s, max %s\n", level,
+				h.mean(), h.stddev(), h.min(), h.max())
+		}
+		if result.responses.responses > 0 {
+			fmt.Fprintf(out, "concurrency %d: responses %s\n", level, result.responses.describeStatusClasses())
+		}
+		if result.errors > 0 {
+			fmt.Fprintf(out, "concurrency %d: %d errors (%s)\n", level, result.errors, result.errorsByCategory.String())
+		}
+		if *tlsSessionTickets && result.tlsHandshakes > 0 {
+			fmt.Fprintf(out, "concurrency %d: %d TLS handshakes, %.1f%% resumed\n", level, result.tlsHandshakes, 100*float64(result.tlsResumed)/float64(result.tlsHandshakes))
+		}
+		if result.responses.redirects > 0 {
+			fmt.Fprintf(out, "concurrency %d: %d redirects\n", level, result.responses.redirects)
+		}
+		if result.responses.mismatched > 0 {
+			fmt.Fprintf(out, "concurrency %d: %d bodies failed SHA-256 verification\n", level, result.responses.mismatched)
 		}
+		if *conditional && result.responses.responses > 0 {
+			fmt.Fprintf(out, "concurrency %d: %.1f%% of responses not modified\n", level, 100*float64(result.responses.notModified)/float64(result.responses.responses))
+		}
+		if *acceptGzip {
+			fmt.Fprintf(out, "concurrency %d: %.1f MB received, %.1f MB decompressed\n", level, float64(result.responses.received)/1e6, float64(result.responses.decoded)/1e6)
+		}
+		return result
+	}
+	// Runs a level -replicates times in a row, returning its mean
+	// throughput.
+	runLevel := func(level int) float64 {
+		var sum float64
+		for r := 0; r < *replicates; r++ {
+			result := runReplicate(level)
+			result.replicate = r
+			results = append(results, result)
+			sum += result.throughput
+		}
+		return sum / float64(*replicates)
+	}
+	if *autoLevels {
+		levels = chooseLevels(runLevel)
+		debugf("-autoLevels tested concurrency levels %s", formatLevels(levels))
+		// Keep the results in order of concurrency, like those of levels
+		// given in order.
+		sort.SliceStable(results, func(i, j int) bool { return results[i].concurrency < results[j].concurrency })
+	} else {
+		var peak float64
+		below := 0
+		for i, level := range levels {
+			throughput := runLevel(level)
+			if throughput > peak {
+				peak, below = throughput, 0
+			} else if *stopBelowPeak > 0 && throughput <= peak*(1-*stopBelowPeak) {
+				below++
+			} else {
+				below = 0
+			}
+			if below == rolledOverLevels && i+1 < len(levels) {
+				infof("throughput has rolled over, so skipping concurrency levels %s", formatLevels(levels[i+1:]))
+				break
+			}
+		}
+	}
+	if *rerunAnomalies {
+		rerunAnomalous(results, runReplicate)
+	}
+	for _, result := range results {
+		denseLatency = append(denseLatency, float64(result.concurrency))
+		denseLatency = append(denseLatency, result.throughput)
+	}
 
-		throughput := runLoadTests(address, host, level, timePerLevel)
-		if *debug {
-			fmt.Printf("%d %d\n", level, throughput)
+	if opts.live != nil && opts.live.statsd != nil {
+		opts.live.statsd.stop()
+	}
+	if opts.tracer != nil {
+		opts.tracer.stop()
+	}
+	if influx != nil {
+		if err := writeInflux(*influxDest, influx.stop()); err != nil {
+			log.Fatalf("could not save samples to influx: %s", err)
+		}
+	}
+
+	if len(types) > 1 {
+		printThroughputByType(out, types, results)
+	}
+
+	if *csvFile != "" {
+		if err := writeMeasurements(*csvFile, results); err != nil {
+			log.Fatalf("could not save measurements: %s", err)
+		}
+	}
+
+	if *histogramFile != "" {
+		if err := writeHistogramLog(*histogramFile, runStart, results); err != nil {
+			log.Fatalf("could not save histograms: %s", err)
 		}
-		denseLatency = append(denseLatency, float64(level))
-		denseLatency = append(denseLatency, float64(throughput))
 	}
 
 	latency := mat.NewDense(len(denseLatency)/2, 2, denseLatency)
 	concurrency := mat.Col(nil, 0, latency)
 	throughput := mat.Col(nil, 1, latency)
 
-	// `f` and `grad` were borrowed from https://play.golang.org/p/wWUH4E5LhP
-	f := func(x []float64) float64 {
-		sigma, kappa, lambda := optvarsToGreek(x)
-		var mismatch float64
-		for i, N := range concurrency {
-			pred := concurrencyToThroughput(N, sigma, kappa, lambda)
-			truth := throughput[i]
-			mismatch += (pred - truth) * (pred - truth)
+	if *rps > 0 {
+		warnf("with -rps, the throughput at each level is just the rate requests were sent at, so the model and its maxRps don't describe the target's capacity; compare latency across levels instead")
+	}
+	fitted := reportFit(out, concurrency, throughput, *fitOpts)
+	model := fitted.model
+	report := newRunReport(types, results, fitted)
+	for _, i := range fitted.outliers {
+		report.Levels[i].Outlier = true
+	}
+	printReplicateStats(out, report.Replicates)
+	if *fitLatency && fitted.empirical != nil {
+		warnf("the model couldn't be fitted, so there's nothing to compare a fit to latency with; skipping -fitLatency")
+	} else if *fitLatency {
+		var c, l []float64
+		for _, result := range results {
+			if result.latency.count() > 0 {
+				c = append(c, float64(result.concurrency))
+				l = append(l, result.latency.mean().Seconds())
+			}
+		}
+		lf, err := newLatencyFit(c, l, model)
+		if err != nil {
+			warnf("could not fit the model to latency: %s", err)
+		} else {
+			printLatencyFit(out, lf)
+			if lf.Disagrees {
+				warnf("the latency fit disagrees with the throughput fit, which suggests a problem with the measurements")
+			}
+			report.LatencyFit = lf
+		}
+	}
+	if *bootstrap > 0 && fitted.empirical != nil {
+		warnf("the model couldn't be fitted, so neither can resamples of the measurements be; skipping -bootstrap")
+	} else if *bootstrap > 0 {
+		c, t, weights := concurrency, throughput, fitted.weights
+		if fitted.excluded != nil {
+			c, t = withoutOutliers(concurrency, throughput, fitted.excluded)
+			weights = without(weights, fitted.excluded)
 		}
-		return mismatch
+		iv := bootstrapModel(c, t, weights, fitted.fit, *bootstrap, rand.New(rand.NewSource(*seed)))
+		printIntervals(out, iv)
+		report.Intervals = &iv
 	}
 
-	grad := func(grad, x []float64) {
-		for i := range grad {
-			grad[i] = 0
+	if *modelFile != "" && fitted.empirical != nil {
+		warnf("the model couldn't be fitted, so not saving it to %s", *modelFile)
+	} else if *modelFile != "" {
+		if err := writeModel(*modelFile, model); err != nil {
+			log.Fatalf("could not save model: %s", err)
 		}
-		sigma, kappa, lambda := optvarsToGreek(x)
-		dSigmaDX, dKappaDX, dLambdaDX := optvarsToGreekDeriv(x)
-		for i, N := range concurrency {
-			pred := concurrencyToThroughput(N, sigma, kappa, lambda)
-			truth := throughput[i]
+	}
 
-			dMismatchDPred := 2 * (pred - truth)
-			dPredDSigma, dPredDKappa, dPredDLambda := concurrencyToThroughputDeriv(N, sigma, kappa, lambda)
+	regressed := false
+	if baseline != nil {
+		fmt.Fprintf(out, "\ncompared with %s:\n", *baselineFile)
+		regressed = compareReports(out, *baseline, report, *tolerance)
+	}
 
-			grad[0] += dMismatchDPred * dPredDSigma * dSigmaDX
-			grad[1] += dMismatchDPred * dPredDKappa * dKappaDX
-			grad[2] += dMismatchDPred * dPredDLambda * dLambdaDX
+	if *plotFile != "" {
+		// Only the measurements are plotted if the model couldn't be
+		// fitted.
+		var plotted *uslModel
+		if fitted.empirical == nil {
+			plotted = &model
+		}
+		if err := writePlot(*plotFile, concurrency, throughput, plotted); err != nil {
+			log.Fatalf("could not save plot: %s", err)
 		}
 	}
 
-	problem := optimize.Problem{
-		Func: f,
-		Grad: grad,
+	if *otlpEndpoint != "" {
+		if err := exportOTLPMetrics(*otlpEndpoint, results, fitted); err != nil {
+			log.Fatalf("could not export metrics: %s", err)
+		}
 	}
-	settings := optimize.DefaultSettings()
-	settings.GradientThreshold = 1e-2 // Looser tolerance because using FD derivative
 
-	initX := []float64{0, -1, -3} // make sure they all start positive
-	result, err := optimize.Local(problem, initX, nil, nil)
-	if err != nil {
-		fmt.Println("Optimization error:", err)
+	if *pushgateway != "" {
+		if err := pushResults(*pushgateway, *pushJob, *pushInstance, results, fitted); err != nil {
+			log.Fatalf("could not push results: %s", err)
+		}
 	}
 
-	sigmaOpt, kappaOpt, lambdaOpt := optvarsToGreek(result.X)
-	fmt.Println("sigma (the overhead of contention): ", sigmaOpt)
-	fmt.Println("kappa (the overhead of crosstalk): ", kappaOpt)
-	fmt.Println("lambda (unloaded performance): ", lambdaOpt)
+	if *htmlReport != "" {
+		meta := runMetadata{
+			Address:      *address,
+			Started:      runStart,
+			TimePerLevel: *timePerLevel,
+			CommandLine:  strings.Join(os.Args, " "),
+		}
+		if *durationWeighting == "concurrency" && levelDurations != nil {
+			// Levels ran for different times.
+			meta.TimePerLevel = 0
+		} else if levelDurations != nil {
+			meta.TimePerLevel = levelDurations[levels[0]]
+		}
+		if err := writeHTMLReport(*htmlReport, meta, report); err != nil {
+			log.Fatalf("could not save HTML report: %s", err)
+		}
+	}
 
-	if *debug {
-		for i, v := range throughput {
-			N := concurrency[i]
-			pred := concurrencyToThroughput(N, sigmaOpt, kappaOpt, lambdaOpt)
-			fmt.Println("true", v, "pred", pred)
+	if *outFile != "" {
+		if err := writeSavedRun(*outFile, runStart, flags, report); err != nil {
+			log.Fatalf("could not save results: %s", err)
 		}
 	}
 
-	maxConcurrency := math.Floor(math.Sqrt((1 - sigmaOpt) / kappaOpt))
-	fmt.Printf("maxConcurrency: %f\n", maxConcurrency)
+	if *dbFile != "" {
+		if err := appendToDB(*dbFile, runStart, flags, report); err != nil {
+			log.Fatalf("could not save to database: %s", err)
+		}
+	}
 
-	maxRps := throughputAtConcurrency(float64(maxConcurrency), kappaOpt, lambdaOpt, sigmaOpt)
-	fmt.Printf("maxRps: %f\n", maxRps)
+	switch {
+	case *outputFormat == "json":
+		if err := writeReport(os.Stdout, report); err != nil {
+			log.Fatalf("could not write report: %s", err)
+		}
+	case *outputFormat == "markdown":
+		writeMarkdownReport(os.Stdout, report)
+	case *quiet && fitted.empirical != nil:
+		printEmpiricalValues(os.Stdout, *fitted.empirical)
+	case *quiet:
+		printModelValues(os.Stdout, model, newGoodnessOfFit(concurrency, throughput, model))
+	}
+
+	_, maxRps := fitted.peak()
+	checks := checkSLA(results, maxRps, sla)
+	if *junitFile != "" {
+		if err := writeJUnit(*junitFile, results, sla.maxErrorRate, checks); err != nil {
+			log.Fatalf("could not save JUnit report: %s", err)
+		}
+	}
+	violated := false
+	for _, c := range checks {
+		if c.failure != "" {
+			errorf("%s", c.failure)
+			violated = true
+		}
+	}
+	if regressed {
+		errorf("throughput fell by more than %g%% of %s's", 100**tolerance, *baselineFile)
+		violated = true
+	}
+	if violated {
+		os.Exit(exitSLAViolated)
+	}
 }
 
-func exUsage(msg string, args ...interface{}) {
-	fmt.Fprintln(os.Stderr, fmt.Sprintf(msg, args...))
-	fmt.Fprintln(os.Stderr, "Try --help for help.")
-	os.Exit(64)
+// Compares the results of a run with those of a baseline, exiting with
+// exitSLAViolated if throughput regressed.
+func compareCommand(args []string) {
+	flags := newFlagSet("compare", " baseline.json current.json")
+	tolerance := flags.Float64("tolerance", 0.05, "fraction by which throughput may fall below the baseline before it's marked as regressed")
+	parseFlags(flags, args)
+	if flags.NArg() != 2 {
+		exUsage("compare takes a baseline and a current run, each saved with -out or -outputFormat json")
+	}
+
+	baseline, err := readRunReport(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("could not read baseline: %s", err)
+	}
+	current, err := readRunReport(flags.Arg(1))
+	if err != nil {
+		log.Fatalf("could not read current run: %s", err)
+	}
+	if compareReports(os.Stdout, baseline, current, *tolerance) {
+		os.Exit(exitSLAViolated)
+	}
 }
 
-func throughputAtConcurrency(n, kappa, lambda, sigma float64) float64 {
-	return (lambda * n) / (1 + (sigma * (n - 1)) + (kappa * n * (n - 1)))
+// Fits the model to measurements saved by `run -csv`, or to any CSV of
+// concurrency and throughput pairs.
+func fitCommand(args []string) {
+	flags := newFlagSet("fit", " measurements.csv...")
+	var (
+		fitOpts      = addFitFlags(flags)
+		modelFile    = flags.String("model", "", "file to save the fitted model to, for use with predict and plot")
+		bootstrap    = flags.Int("bootstrap", 0, "resamples of the measurements to refit the model to, to give 95% confidence intervals for it; 0 disables")
+		outputFormat = flags.String("outputFormat", "text", "format to report the model in: text, or json to write it to stdout and everything else to stderr")
+	)
+	parseFlags(flags, args)
+	fitOpts.validate()
+	if *outputFormat != "text" && *outputFormat != "json" {
+		exUsage("unknown -outputFormat: '%s': expected text or json", *outputFormat)
+	}
+	if flags.NArg() == 0 {
+		exUsage("fit takes one or more CSV files of measurements, or - for stdin")
+	}
+
+	var concurrency, throughput []float64
+	for _, filename := range flags.Args() {
+		c, t, err := readMeasurements(filename)
+		if err != nil {
+			log.Fatalf("could not read measurements: %s", err)
+		}
+		concurrency = append(concurrency, c...)
+		throughput = append(throughput, t...)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputFormat == "json" {
+		out = os.Stderr
+	}
+	fitted := reportFit(out, concurrency, throughput, *fitOpts)
+	model := fitted.model
+	report := newModelReport(concurrency, throughput, fitted)
+	if *bootstrap > 0 && fitted.empirical != nil {
+		warnf("the model couldn't be fitted, so neither can resamples of the measurements be; skipping -bootstrap")
+	} else if *bootstrap > 0 {
+		weights := fitted.weights
+		if fitted.excluded != nil {
+			concurrency, throughput = withoutOutliers(concurrency, throughput, fitted.excluded)
+			weights = without(weights, fitted.excluded)
+		}
+		iv := bootstrapModel(concurrency, throughput, weights, fitted.fit, *bootstrap, rand.New(rand.NewSource(1)))
+		printIntervals(out, iv)
+		report.Intervals = &iv
+	}
+
+	if *modelFile != "" && fitted.empirical != nil {
+		warnf("the model couldn't be fitted, so not saving it to %s", *modelFile)
+	} else if *modelFile != "" {
+		if err := writeModel(*modelFile, model); err != nil {
+			log.Fatalf("could not save model: %s", err)
+		}
+	}
+
+	if *outputFormat == "json" {
+		if err := writeReport(os.Stdout, report); err != nil {
+			log.Fatalf("could not write report: %s", err)
+		}
+	}
 }
 
-// These math functions were borrowed from https://play.golang.org/p/wWUH4E5LhP
-func optvarsToGreek(x []float64) (sigma, kappa, lambda float64) {
-	return math.Exp(x[0]), math.Exp(x[1]), math.Exp(x[2])
+// Prints the throughput a saved model predicts at the given concurrency
+// levels, along with its maxima.
+func predictCommand(args []string) {
+	flags := newFlagSet("predict", " model.json")
+	concurrencyLevels := flags.String("concurrencyLevels", "", "levels of concurrency to predict throughput at, as a comma-separated list of levels and ranges such as 1..100:10")
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		exUsage("predict takes a single model file")
+	}
+
+	model, err := readModel(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("could not read model: %s", err)
+	}
+
+	var levels []int
+	if *concurrencyLevels != "" {
+		levels, err = parseLevels(*concurrencyLevels)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	printModel(os.Stdout, model)
+	printPredictions(os.Stdout, model, levels)
 }
 
-func optvarsToGreekDeriv(x []float64) (dSigmaDX, dKappaDX, dLambdaDX float64) {
-	return math.Exp(x[0]), math.Exp(x[1]), math.Exp(x[2])
+// Plots saved measurements and models. Files ending in .json are read as
+// models and anything else as CSV measurements; measurements without a
+// model are fitted first.
+func plotCommand(args []string) {
+	flags := newFlagSet("plot", " file...")
+	output := flags.String("o", "", "file ending in .svg to save the plot to as an SVG, instead of drawing it as text")
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		exUsage("plot takes a CSV file of measurements, a model file, or both")
+	}
+	if *output != "" && !isSVG(*output) {
+		exUsage("plots can only be saved as SVG, to a file ending in .svg: '%s'", *output)
+	}
+
+	var concurrency, throughput []float64
+	var model *uslModel
+	for _, filename := range flags.Args() {
+		if strings.HasSuffix(filename, ".json") {
+			m, err := readModel(filename)
+			if err != nil {
+				log.Fatalf("could not read model: %s", err)
+			}
+			model = &m
+			continue
+		}
+
+		c, t, err := readMeasurements(filename)
+		if err != nil {
+			log.Fatalf("could not read measurements: %s", err)
+		}
+		concurrency = append(concurrency, c...)
+		throughput = append(throughput, t...)
+	}
+
+	if model == nil && len(concurrency) > 0 {
+		m, err := fitUSL(concurrency, throughput)
+		if failure := fitFailure(m, err, newGoodnessOfFit(concurrency, throughput, m)); failure != "" {
+			warnf("could not fit the model, so plotting only the measurements: %s", failure)
+		} else {
+			if err != nil {
+				warnf("optimization error: %s", err)
+			}
+			model = &m
+		}
+	}
+
+	if *output != "" {
+		if err := writePlot(*output, concurrency, throughput, model); err != nil {
+			log.Fatalf("could not save plot: %s", err)
+		}
+		return
+	}
+	plotText(os.Stdout, concurrency, throughput, model)
 }
 
-func concurrencyToThroughput(concurrency, sigma, kappa, lambda float64) float64 {
-	N := concurrency
-	return lambda * N / (1 + sigma*(N-1) + kappa*N*(N-1))
+// fitOptions controls how reportFit fits the model and what it prints.
+type fitOptions struct {
+	residuals, debug bool
+	// Measurements further than this from the fit, by modified z-score, are
+	// reported as outliers; 0 disables the check.
+	outlierThreshold float64
+	// Whether to fit the model again without any outliers.
+	excludeOutliers bool
+	// Concurrency levels to print the model's predictions at.
+	predictAt levelsFlag
+	// How to weight the measurements in the fit; see fitWeights.
+	weights string
+	// Whether to fit with the Huber loss instead of least squares.
+	robust bool
 }
 
-func concurrencyToThroughputDeriv(concurrency, sigma, kappa, lambda float64) (dSigma, dKappa, dLambda float64) {
-	// X(N) = lambda * N / (1 + sigma*(N-1) + kappa*N*(N-1))
-	N := concurrency
-	num := lambda * N
-	denom := 1 + sigma*(N-1) + kappa*N*(N-1)
-	dSigma = -(num / (denom * denom)) * (N - 1)
-	dKappa = -(num / (denom * denom)) * (N - 1) * N
-	dLambda = N / denom
-	return dSigma, dKappa, dLambda
+// Adds the flags for fitOptions that run and fit share.
+func addFitFlags(flags *flag.FlagSet) *fitOptions {
+	opts := &fitOptions{}
+	flags.BoolVar(&opts.residuals, "residuals", false, "print the residual of the fit at each concurrency level")
+	flags.BoolVar(&opts.debug, "debug", false, "print out some extra information for debugging, as -logLevel debug does")
+	flags.Float64Var(&opts.outlierThreshold, "outlierThreshold", 3.5, "modified z-score of a measurement's residual above which it's reported as an outlier; 0 disables")
+	flags.BoolVar(&opts.excludeOutliers, "excludeOutliers", false, "fit the model again without any outliers")
+	flags.StringVar(&opts.weights, "weights", "none", "how to weight measurements in the fit: none, variance (the inverse of each level's variance across -replicates) or throughput (its inverse)")
+	flags.BoolVar(&opts.robust, "robust", false, "fit with the Huber loss instead of least squares, so that a single wild level has less pull")
+	flags.Var(&opts.predictAt, "predictAt", "concurrency levels to print the throughput and latency the model predicts at, such as 15,50,200")
+	return opts
 }
 
-// Converts a slice of chan int to a slice of int.
-func chansToSlice(cs []<-chan int, size int) []int {
-	s := make([]int, size)
-	for i, c := range cs {
-		for m := range c {
-			s[i] = m
-		}
+// fitResult is what reportFit found.
+type fitResult struct {
+	// The fitted model, or unfittedModel if it couldn't be fitted.
+	model uslModel
+	// The indices of any outliers among the measurements, and of those left
+	// out of the fit, with -excludeOutliers and if it could be fitted
+	// without them.
+	outliers, excluded []int
+	// How Amdahl's law fits the same measurements, if there were enough.
+	amdahl *modelComparison
+	// The peak measured, if the model couldn't be fitted.
+	empirical *empiricalPeak
+	// Why the model is extrapolating, if it is.
+	extrapolating string
+	// How well the model predicts measurements left out of its fit, if there
+	// were enough.
+	crossValidation *crossValidation
+	// The weights of all the measurements, nil if they're unweighted, and
+	// the function fitting the model, for refitting it as it was fitted.
+	weights []float64
+	fit     fitFunc
+}
+
+// Returns the concurrency at which throughput peaks and the throughput
+// there: the model's maxima or, if it couldn't be fitted, the peak
+// measured.
+func (f fitResult) peak() (concurrency, rps float64) {
+	if f.empirical != nil {
+		return f.empirical.Concurrency, f.empirical.Throughput
 	}
-	return s
+	return f.model.maxConcurrency(), f.model.maxRps()
 }
 
-func newClient(
-	compress bool,
-	https bool,
-	noreuse bool,
-	maxConn int,
-) *http.Client {
-	tr := http.Transport{
-		DisableCompression:  !compress,
-		DisableKeepAlives:   noreuse,
-		MaxIdleConnsPerHost: maxConn,
-		Proxy:               http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout: 5 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: 5 * time.Second,
-	}
-	if https {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
-	return &http.Client{
-		Timeout:   10 * time.Second,
-		Transport: &tr,
+// Exits with a usage error if the options are invalid, and lowers the log
+// level for -debug.
+func (opts *fitOptions) validate() {
+	switch opts.weights {
+	case "none", "variance", "throughput":
+	default:
+		exUsage("-weights must be none, variance or throughput")
+	}
+	if opts.debug {
+		logger.level = logDebug
 	}
 }
 
-func sendRequest(
-	client *http.Client,
-	url *url.URL,
-	host *string,
-	bodyBuffer []byte,
-) error {
-	req, err := http.NewRequest("GET", url.String(), nil)
-	req.Close = false
+// Fits the model to the measurements and prints the result.
+func reportFit(w io.Writer, concurrency, throughput []float64, opts fitOptions) fitResult {
+	// The weights are found once, for all the measurements, and those of
+	// any left out of a fit are left out with them.
+	weights, err := fitWeights(opts.weights, concurrency, throughput)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		fmt.Fprintf(os.Stderr, "\n")
+		warnf("could not weight the measurements, so fitting without weights: %s", err)
 	}
-	if *host != "" {
-		req.Host = *host
+	fitModel := fitFunc(fitWeightedUSL)
+	if opts.robust {
+		fitModel = fitRobustUSL
 	}
 
-	response, err := client.Do(req)
+	model, err := fitModel(concurrency, throughput, weights)
+	if failure := fitFailure(model, err, newGoodnessOfFit(concurrency, throughput, model)); failure != "" {
+		warnf("could not fit the model, so reporting the peak measured instead: %s", failure)
+		peak := newEmpiricalPeak(concurrency, throughput)
+		printEmpiricalPeak(w, peak)
+		return fitResult{model: unfittedModel, empirical: &peak, weights: weights, fit: fitModel}
+	} else if err != nil {
+		warnf("optimization error: %s", err)
+	}
 
-	if err != nil {
-		return err
-	} else {
-		defer response.Body.Close()
-		io.CopyBuffer(ioutil.Discard, response.Body, bodyBuffer)
-		return nil
+	var outliers, excluded []int
+	fitted, fittedThroughput, fittedWeights := concurrency, throughput, weights
+	if opts.outlierThreshold > 0 {
+		var predicted []float64
+		outliers, predicted = findOutliers(concurrency, throughput, weights, fitModel, opts.outlierThreshold)
+		for j, i := range outliers {
+			warnf("outlier at concurrency %.0f: throughput %.0f, %.0f predicted by the rest", concurrency[i], throughput[i], predicted[j])
+		}
+		if len(outliers) > 0 && opts.excludeOutliers {
+			// The fit without the outliers is checked as the first one was,
+			// and the first kept if it fails.
+			c, t := withoutOutliers(concurrency, throughput, outliers)
+			cWeights := without(weights, outliers)
+			refit, err := fitModel(c, t, cWeights)
+			failure := fitFailure(refit, err, newGoodnessOfFit(c, t, refit))
+			if len(c) < minFitMeasurements {
+				failure = fmt.Sprintf("only %d measurements would be left, and it needs at least %d", len(c), minFitMeasurements)
+			}
+			if failure != "" {
+				warnf("could not fit the model without the outliers, so keeping them in the fit: %s", failure)
+			} else {
+				infof("excluding %d outliers from the fit", len(outliers))
+				if err != nil {
+					warnf("optimization error: %s", err)
+				}
+				model, excluded = refit, outliers
+				fitted, fittedThroughput, fittedWeights = c, t, cWeights
+			}
+		}
+	}
+
+	extrapolating := checkFit(model, fitted)
+	if extrapolating != "" {
+		warnf("the model is extrapolating, so don't trust its maxima: %s", extrapolating)
+	}
+
+	printModel(w, model)
+	fit := newGoodnessOfFit(fitted, fittedThroughput, model)
+	fmt.Fprintf(w, "R²: %f, RMSE: %f\n", fit.rSquared, fit.rmse)
+	cv := crossValidate(fitted, fittedThroughput, fittedWeights, fitModel)
+	if cv != nil {
+		printCrossValidation(w, cv)
+	}
+	amdahl := compareAmdahl(fitted, fittedThroughput, fittedWeights, opts.robust, model)
+	if amdahl != nil {
+		printComparison(w, amdahl)
+	}
+
+	for i, v := range throughput {
+		debugf("concurrency %.0f: throughput %f, %f predicted", concurrency[i], v, model.throughputAt(concurrency[i]))
+	}
+
+	if opts.residuals {
+		printResiduals(w, concurrency, throughput, model)
+	}
+
+	printPredictions(w, model, opts.predictAt)
+
+	return fitResult{
+		model:           model,
+		outliers:        outliers,
+		excluded:        excluded,
+		amdahl:          amdahl,
+		extrapolating:   extrapolating,
+		crossValidation: cv,
+		weights:         weights,
+		fit:             fitModel,
 	}
 }
 
-// Runs a single load test, returns how many requests were sent in a second.
-func runLoadTest(client *http.Client, destURL *url.URL, host *string, wg *sync.WaitGroup, startWg *sync.WaitGroup, timePerLevel *time.Duration) <-chan int {
-	out := make(chan int, 1)
-	bodyBuffer := make([]byte, 50000)
+// How many levels in a row throughput has to be below its peak by
+// -stopBelowPeak for it to have rolled over.
+const rolledOverLevels = 2
 
-	go func() {
-		defer wg.Done()
-		// Roughly synchronize the start of all our load test goroutines
-		startWg.Wait()
-		start := time.Now()
-		requests := 0
-		for ; time.Now().Sub(start) <= *timePerLevel; requests++ {
-			err := sendRequest(client, destURL, host, bodyBuffer)
+// Formats concurrency levels as a comma-separated list, as parseLevels
+// takes them.
+func formatLevels(levels []int) string {
+	s := make([]string, len(levels))
+	for i, level := range levels {
+		s[i] = strconv.Itoa(level)
+	}
+	return strings.Join(s, ",")
+}
 
+// Parses a comma-separated list of concurrency levels, any of which may be
+// a range, start..end:step, such as 1..100:10 for 1, 11, 21 and so on up
+// to 91. The step defaults to 1, and every level must be at least 1. A
+// level given more than once, as by a range and a level it includes, is
+// only returned the first time; -replicates is how levels are repeated.
+func parseLevels(s string) ([]int, error) {
+	var levels []int
+	seen := make(map[int]bool)
+	add := func(level int) {
+		if !seen[level] {
+			seen[level] = true
+			levels = append(levels, level)
+		}
+	}
+	for _, l := range strings.Split(s, ",") {
+		bounds := strings.SplitN(l, "..", 2)
+		if len(bounds) == 1 {
+			level, err := strconv.Atoi(l)
 			if err != nil {
-				// TODO: have an err channel so we can report the # of errs
-				log.Printf("Error issuing request %v", err)
-				continue
+				return nil, fmt.Errorf("unknown concurrency level: %s, %s", l, err)
 			}
+			if level < 1 {
+				return nil, fmt.Errorf("invalid concurrency level: %s, must be at least 1", l)
+			}
+			add(level)
+			continue
 		}
-		rps := requests / int(timePerLevel.Seconds())
-		out <- rps
-		close(out)
-	}()
 
-	return out
+		end, step := bounds[1], "1"
+		if i := strings.Index(end, ":"); i >= 0 {
+			end, step = end[:i], end[i+1:]
+		}
+		var r [3]int
+		for i, n := range []string{bounds[0], end, step} {
+			var err error
+			if r[i], err = strconv.Atoi(n); err != nil {
+				return nil, fmt.Errorf("unknown concurrency range: %s, %s", l, err)
+			}
+		}
+		if r[0] < 1 || r[2] < 1 || r[1] < r[0] {
+			return nil, fmt.Errorf("invalid concurrency range: %s, must be start..end:step with start at least 1 and at most end, and step at least 1", l)
+		}
+		for level := r[0]; level <= r[1]; level += r[2] {
+			add(level)
+		}
+	}
+	return levels, nil
 }
 
-// returns how many requests were sent in one second at concurrencyLevel
-func runLoadTests(address *string, host *string, concurrencyLevel int, timePerLevel *time.Duration) int {
-	// FIXME: wire these options through flags if needed or remove.
-	client := newClient(false, false, false, concurrencyLevel)
-	destURL, err := url.Parse(*address)
-	if err != nil {
-		exUsage("invalid URL: '%s': %s\n", address, err.Error())
-	}
+// levelsFlag is a flag giving a comma-separated list of concurrency levels.
+type levelsFlag []int
 
-	var wg sync.WaitGroup
-	var startWg sync.WaitGroup
-	// a slice of channels containing throughput per goroutine
-	var requests []<-chan int
-	startWg.Add(1)
-	wg.Add(concurrencyLevel)
+func (l *levelsFlag) String() string {
+	return formatLevels(*l)
+}
 
-	for i := 0; i < concurrencyLevel; i++ {
-		if err != nil {
-			log.Fatalf("did not connect: %v", err)
-		}
-		request := runLoadTest(client, destURL, host, &wg, &startWg, timePerLevel)
-		requests = append(requests, request)
-	}
+func (l *levelsFlag) Set(value string) error {
+	levels, err := parseLevels(value)
+	*l = levels
+	return err
+}
 
-	startWg.Done()
-	wg.Wait()
-	requestsPerWorker := chansToSlice(requests, concurrencyLevel)
-	totalRequests := 0
-	for _, requests := range requestsPerWorker {
-		totalRequests += requests
-	}
+// stringsFlag is a flag that may be given more than once, collecting each
+// value.
+type stringsFlag []string
 
-	return totalRequests
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func exUsage(msg string, args ...interface{}) {
+	fmt.Fprintln(os.Stderr, fmt.Sprintf(msg, args...))
+	fmt.Fprintln(os.Stderr, "Try --help for help.")
+	os.Exit(64)
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []int
+	}{
+		{"1", []int{1}},
+		{"1,5,10,20,30", []int{1, 5, 10, 20, 30}},
+		{"1..4", []int{1, 2, 3, 4}},
+		{"3..3", []int{3}},
+		{"1..100:10", []int{1, 11, 21, 31, 41, 51, 61, 71, 81, 91}},
+		{"10..30:10", []int{10, 20, 30}},
+		{"1,2,5..20:5,50", []int{1, 2, 5, 10, 15, 20, 50}},
+		{"1..10:3,10", []int{1, 4, 7, 10}},
+		{"1,5,5", []int{1, 5}},
+		{"5..7,1..10", []int{5, 6, 7, 1, 2, 3, 4, 8, 9, 10}},
+		{"", nil},
+		{"a", nil},
+		{"0", nil},
+		{"1,-1", nil},
+		{"0..3", nil},
+		{"-2..2", nil},
+		{"5..1", nil},
+		{"1..10:0", nil},
+		{"1..10:-1", nil},
+		{"a..b", nil},
+		{"1..", nil},
+		{"1..10:", nil},
+	} {
+		got, err := parseLevels(tc.s)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("parseLevels(%q) = %v, want an error", tc.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLevels(%q): %s", tc.s, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseLevels(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
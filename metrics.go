@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// liveStats is updated by every worker as requests complete, so that a run
+// can be watched while it's in progress.
+type liveStats struct {
+	concurrency  int64
+	requests     uint64
+	errors       uint64
+	latencySumNs uint64
+
+	mu sync.Mutex
+	// The latency of the last level to finish.
+	lastLatency latencySketch
+
+	// Streams latencies to StatsD, if set.
+	statsd *statsdClient
+}
+
+func (s *liveStats) recordSuccess(latency time.Duration) {
+	atomic.AddUint64(&s.requests, 1)
+	atomic.AddUint64(&s.latencySumNs, uint64(latency))
+	if s.statsd != nil {
+		s.statsd.timing(latency)
+	}
+}
+
+func (s *liveStats) recordError() {
+	atomic.AddUint64(&s.errors, 1)
+}
+
+func (s *liveStats) startLevel(concurrency int) {
+	atomic.StoreInt64(&s.concurrency, int64(concurrency))
+}
+
+func (s *liveStats) finishLevel(r levelResult) {
+	atomic.StoreInt64(&s.concurrency, 0)
+	s.mu.Lock()
+	s.lastLatency = r.latency
+	s.mu.Unlock()
+}
+
+// Writes s in the Prometheus text exposition format. The latency summary's
+// quantiles are those of the last level to finish, while its sum and count
+// cover the whole run.
+func (s *liveStats) writeMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP http_max_rps_concurrency Workers sending requests at the current level.")
+	fmt.Fprintln(w, "# TYPE http_max_rps_concurrency gauge")
+	fmt.Fprintf(w, "http_max_rps_concurrency %d\n", atomic.LoadInt64(&s.concurrency))
+
+	fmt.Fprintln(w, "# HELP http_max_rps_requests_total Requests that succeeded.")
+	fmt.Fprintln(w, "# TYPE http_max_rps_requests_total counter")
+	fmt.Fprintf(w, "http_max_rps_requests_total %d\n", atomic.LoadUint64(&s.requests))
+
+	fmt.Fprintln(w, "# HELP http_max_rps_errors_total Requests that failed.")
+	fmt.Fprintln(w, "# TYPE http_max_rps_errors_total counter")
+	fmt.Fprintf(w, "http_max_rps_errors_total %d\n", atomic.LoadUint64(&s.errors))
+
+	fmt.Fprintln(w, "# HELP http_max_rps_request_duration_seconds Latency of successful requests.")
+	fmt.Fprintln(w, "# TYPE http_max_rps_request_duration_seconds summary")
+	s.mu.Lock()
+	if h := s.lastLatency; h != nil {
+		for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+			fmt.Fprintf(w, "http_max_rps_request_duration_seconds{quantile=\"%g\"} %g\n", q, h.valueAtPercentile(q*100).Seconds())
+		}
+	}
+	s.mu.Unlock()
+	fmt.Fprintf(w, "http_max_rps_request_duration_seconds_sum %g\n", time.Duration(atomic.LoadUint64(&s.latencySumNs)).Seconds())
+	fmt.Fprintf(w, "http_max_rps_request_duration_seconds_count %d\n", atomic.LoadUint64(&s.requests))
+}
+
+// Serves s at /metrics on addr for as long as the run lasts.
+func serveMetrics(addr string, s *liveStats) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.writeMetrics(w)
+	})
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
+
+// Writes the fitted model and the throughput measured at each level in the
+// Prometheus text exposition format. The maxima are the peak measured if
+// the model couldn't be fitted, and its coefficients are left out.
+func writeResultMetrics(w io.Writer, results []levelResult, f fitResult) {
+	m := f.model
+	maxConcurrency, maxRps := f.peak()
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"http_max_rps_max_rps", "Throughput predicted at the optimal concurrency.", maxRps},
+		{"http_max_rps_max_concurrency", "Concurrency at which throughput peaks.", maxConcurrency},
+		{"http_max_rps_sigma", "The overhead of contention.", m.Sigma},
+		{"http_max_rps_kappa", "The overhead of crosstalk.", m.Kappa},
+		{"http_max_rps_lambda", "Unloaded performance.", m.Lambda},
+	}
+	for _, g := range gauges {
+		if math.IsNaN(g.value) || math.IsInf(g.value, 0) {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		fmt.Fprintf(w, "%s %g\n", g.name, g.value)
+	}
+
+	fmt.Fprintln(w, "# HELP http_max_rps_throughput Requests per second measured at each concurrency level.")
+	fmt.Fprintln(w, "# TYPE http_max_rps_throughput gauge")
+	labelReplicates := replicated(results)
+	for _, r := range results {
+		if labelReplicates {
+			fmt.Fprintf(w, "http_max_rps_throughput{concurrency=\"%d\",replicate=\"%d\"} %g\n", r.concurrency, r.replicate, r.throughput)
+		} else {
+			fmt.Fprintf(w, "http_max_rps_throughput{concurrency=\"%d\"} %g\n", r.concurrency, r.throughput)
+		}
+	}
+}
+
+// Returns whether any level was run more than once, with -replicates, in
+// which case each measurement of throughput is labeled with its replicate
+// to tell the measurements at a level apart.
+func replicated(results []levelResult) bool {
+	for _, r := range results {
+		if r.replicate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Pushes the results of a run to the Prometheus Pushgateway at gateway,
+// replacing any metrics already grouped under job and instance.
+func pushResults(gateway, job, instance string, results []levelResult, f fitResult) error {
+	u := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	if instance != "" {
+		u += "/instance/" + url.PathEscape(instance)
+	}
+
+	var body bytes.Buffer
+	writeResultMetrics(&body, results, f)
+	req, err := http.NewRequest("PUT", u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	response, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway responded %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// requestType is one kind of request the load test issues, chosen in
+// proportion to its weight.
+type requestType struct {
+	name   string
+	method string
+	url    *url.URL
+	header http.Header
+	body   []byte
+	weight int
+}
+
+// parseMix parses a mix such as "GET /read:90,POST /write:10" into
+// request types. The method may be left out, in which case method is used,
+// and the weight may follow '=' instead of ':', as in "/api=80,/health=20".
+// Targets are resolved against base, so they may be paths or full URLs.
+func parseMix(mix string, base *url.URL, method string) ([]requestType, error) {
+	var types []requestType
+	for _, entry := range strings.Split(mix, ",") {
+		entry = strings.TrimSpace(entry)
+		i := strings.LastIndexAny(entry, ":=")
+		if i < 0 {
+			return nil, fmt.Errorf("missing weight in '%s'", entry)
+		}
+		weight, err := strconv.Atoi(entry[i+1:])
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight in '%s'", entry)
+		}
+
+		t, err := parseTarget(entry[:i], base, method)
+		if err != nil {
+			return nil, fmt.Errorf("%s in '%s'", err, entry)
+		}
+		t.weight = weight
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no requests given")
+	}
+	return types, nil
+}
+
+// parseScenario parses an ordered list of steps such as
+// "POST /login,GET /fetch,POST /post" into request types, one per step.
+// Each step is written as in parseMix, without the weight.
+func parseScenario(scenario string, base *url.URL, method string) ([]requestType, error) {
+	var types []requestType
+	for _, step := range strings.Split(scenario, ",") {
+		t, err := parseTarget(step, base, method)
+		if err != nil {
+			return nil, fmt.Errorf("%s in '%s'", err, strings.TrimSpace(step))
+		}
+		t.weight = 1
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// Parses "[METHOD] TARGET", using method if none is given.
+func parseTarget(s string, base *url.URL, method string) (requestType, error) {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		fields = []string{method, fields[0]}
+	case 2:
+	default:
+		return requestType{}, errors.New("expected '[METHOD] TARGET'")
+	}
+	ref, err := url.Parse(fields[1])
+	if err != nil {
+		return requestType{}, err
+	}
+	return requestType{
+		name:   strings.ToUpper(fields[0]) + " " + fields[1],
+		method: strings.ToUpper(fields[0]),
+		url:    base.ResolveReference(ref),
+	}, nil
+}
+
+// pickRequestType returns the index of a request type, chosen at random in
+// proportion to the weights.
+func pickRequestType(types []requestType, rng *rand.Rand) int {
+	if len(types) == 1 {
+		return 0
+	}
+	total := 0
+	for _, t := range types {
+		total += t.weight
+	}
+	n := rng.Intn(total)
+	for i, t := range types {
+		if n < t.weight {
+			return i
+		}
+		n -= t.weight
+	}
+	return len(types) - 1
+}
+
+// Prints the throughput of each request type at every concurrency level.
+func printThroughputByType(w io.Writer, types []requestType, results []levelResult) {
+	fmt.Fprintln(w, "throughput by request type:")
+	for _, r := range results {
+		parts := make([]string, len(types))
+		for i, t := range types {
+			parts[i] = fmt.Sprintf("%s: %.1f", t.name, r.throughputByType[i])
+		}
+		fmt.Fprintf(w, "  %d: %.1f rps (%s)\n", r.concurrency, r.throughput, strings.Join(parts, ", "))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	base, _ := url.Parse("http://example.com/api/")
+	type want struct {
+		name, method, url string
+		weight            int
+	}
+	for _, tc := range []struct {
+		mix  string
+		want []want
+		err  bool
+	}{
+		{mix: "GET /read:90,POST /write:10", want: []want{
+			{"GET /read", "GET", "http://example.com/read", 90},
+			{"POST /write", "POST", "http://example.com/write", 10},
+		}},
+		{mix: "items=80, health=20", want: []want{
+			{"GET items", "GET", "http://example.com/api/items", 80},
+			{"GET health", "GET", "http://example.com/api/health", 20},
+		}},
+		{mix: "put http://other:8080/x:1", want: []want{
+			{"PUT http://other:8080/x", "PUT", "http://other:8080/x", 1},
+		}},
+		{mix: "/read", err: true},
+		{mix: "/read:0", err: true},
+		{mix: "/read:-1", err: true},
+		{mix: "/read:ten", err: true},
+		{mix: "GET /read extra:1", err: true},
+	} {
+		types, err := parseMix(tc.mix, base, "GET")
+		if (err != nil) != tc.err {
+			t.Errorf("%s: got error %v, want error %t", tc.mix, err, tc.err)
+			continue
+		}
+		if len(types) != len(tc.want) {
+			t.Errorf("%s: got %d request types, want %d", tc.mix, len(types), len(tc.want))
+			continue
+		}
+		for i, rt := range types {
+			got := want{rt.name, rt.method, rt.url.String(), rt.weight}
+			if got != tc.want[i] {
+				t.Errorf("%s: got %+v, want %+v", tc.mix, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestParseScenario(t *testing.T) {
+	base, _ := url.Parse("http://example.com/")
+	types, err := parseScenario("POST /login, /fetch,DELETE /session", base, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, rt := range types {
+		names = append(names, rt.name)
+		if rt.weight != 1 {
+			t.Errorf("%s: got weight %d, want 1", rt.name, rt.weight)
+		}
+	}
+	if want := []string{"POST /login", "GET /fetch", "DELETE /session"}; len(names) != 3 || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("got steps %v, want %v", names, want)
+	}
+	if _, err := parseScenario("GET /a b", base, "GET"); err == nil {
+		t.Error("got no error for a step with too many fields")
+	}
+}
+
+func TestPickRequestType(t *testing.T) {
+	types := []requestType{{weight: 70}, {weight: 20}, {weight: 10}}
+	rng := rand.New(rand.NewSource(1))
+	const picks = 100000
+	counts := make([]int, len(types))
+	for i := 0; i < picks; i++ {
+		counts[pickRequestType(types, rng)]++
+	}
+	for i, rt := range types {
+		if got, want := float64(counts[i])/picks, float64(rt.weight)/100; !near(got, want, 0.05) {
+			t.Errorf("type %d: picked %.3f of the time, want %.2f", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The subset of OTLP's JSON encoding needed to export metrics and spans
+// over OTLP/HTTP without depending on the OpenTelemetry SDK.
+type (
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpMetricsRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpMetric struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description,omitempty"`
+		Unit        string    `json:"unit,omitempty"`
+		Gauge       otlpGauge `json:"gauge"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpDataPoint struct {
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+		TimeUnixNano string         `json:"timeUnixNano"`
+		AsDouble     float64        `json:"asDouble"`
+	}
+
+	otlpTracesRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	otlpSpanKindClient  = 3
+	otlpStatusCodeError = 2
+)
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int) otlpKeyValue {
+	s := strconv.Itoa(value)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+var (
+	otlpServiceResource = otlpResource{Attributes: []otlpKeyValue{otlpString("service.name", "http-max-rps")}}
+	otlpScopeName       = otlpScope{Name: "http-max-rps"}
+)
+
+// Posts v as JSON to path under the OTLP/HTTP endpoint.
+func otlpPost(endpoint, path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(endpoint, "/") + path
+	response, err := (&http.Client{Timeout: 10 * time.Second}).Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s responded %s: %s", u, response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Exports the fitted model and the throughput measured at each level as
+// OTLP gauges. The maxima are the peak measured if the model couldn't be
+// fitted, and values that aren't finite, which JSON can't encode, are left
+// out.
+func exportOTLPMetrics(endpoint string, results []levelResult, f fitResult) error {
+	m := f.model
+	maxConcurrency, maxRps := f.peak()
+	now := otlpTime(time.Now())
+	finite := func(x float64) bool {
+		return !math.IsNaN(x) && !math.IsInf(x, 0)
+	}
+	var metrics []otlpMetric
+	gauge := func(name, description, unit string, value float64) {
+		if finite(value) {
+			metrics = append(metrics, otlpMetric{
+				Name: name, Description: description, Unit: unit,
+				Gauge: otlpGauge{DataPoints: []otlpDataPoint{{TimeUnixNano: now, AsDouble: value}}},
+			})
+		}
+	}
+	gauge("http_max_rps.max_rps", "Throughput predicted at the optimal concurrency.", "{request}/s", maxRps)
+	gauge("http_max_rps.max_concurrency", "Concurrency at which throughput peaks.", "{worker}", maxConcurrency)
+	gauge("http_max_rps.sigma", "The overhead of contention.", "1", m.Sigma)
+	gauge("http_max_rps.kappa", "The overhead of crosstalk.", "1", m.Kappa)
+	gauge("http_max_rps.lambda", "Unloaded performance.", "{request}/s", m.Lambda)
+
+	throughput := otlpMetric{
+		Name:        "http_max_rps.throughput",
+		Description: "Requests per second measured at each concurrency level.",
+		Unit:        "{request}/s",
+	}
+	labelReplicates := replicated(results)
+	for _, r := range results {
+		if !finite(r.throughput) {
+			continue
+		}
+		attributes := []otlpKeyValue{otlpInt("concurrency", r.concurrency)}
+		if labelReplicates {
+			attributes = append(attributes, otlpInt("replicate", r.replicate))
+		}
+		throughput.Gauge.DataPoints = append(throughput.Gauge.DataPoints, otlpDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: now,
+			AsDouble:     r.throughput,
+		})
+	}
+	metrics = append(metrics, throughput)
+
+	return otlpPost(endpoint, "/v1/metrics", otlpMetricsRequest{[]otlpResourceMetrics{{
+		Resource:     otlpServiceResource,
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScopeName, Metrics: metrics}},
+	}}})
+}
+
+// otlpTracer records a client span for a sample of requests, passing its
+// context on in a traceparent header so that the target's spans join the
+// same trace. Spans are exported in batches once a second.
+type otlpTracer struct {
+	endpoint   string
+	sampleRate float64
+	spans      chan otlpSpan
+	done       chan struct{}
+	stopped    chan struct{}
+}
+
+func newOTLPTracer(endpoint string, sampleRate float64) *otlpTracer {
+	t := &otlpTracer{
+		endpoint:   endpoint,
+		sampleRate: sampleRate,
+		spans:      make(chan otlpSpan, 10000),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Starts a span for req if it's sampled, returning nil otherwise.
+func (t *otlpTracer) start(req *http.Request) *otlpSpan {
+	if mathrand.Float64() >= t.sampleRate {
+		return nil
+	}
+	var ids [24]byte
+	rand.Read(ids[:])
+	span := &otlpSpan{
+		TraceID:           hex.EncodeToString(ids[:16]),
+		SpanID:            hex.EncodeToString(ids[16:]),
+		Name:              req.Method,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: otlpTime(time.Now()),
+		Attributes: []otlpKeyValue{
+			otlpString("http.request.method", req.Method),
+			otlpString("url.full", req.URL.String()),
+		},
+	}
+	req.Header.Set("traceparent", "00-"+span.TraceID+"-"+span.SpanID+"-01")
+	return span
+}
+
+// Ends span with the status the response had, or err if there wasn't one.
+// Spans are dropped rather than holding up the worker if the tracer falls
+// behind.
+func (t *otlpTracer) end(span *otlpSpan, status int, err error) {
+	span.EndTimeUnixNano = otlpTime(time.Now())
+	if err != nil {
+		span.Status = otlpStatus{Code: otlpStatusCodeError, Message: err.Error()}
+	} else {
+		span.Attributes = append(span.Attributes, otlpInt("http.response.status_code", status))
+		if status >= 500 {
+			span.Status.Code = otlpStatusCodeError
+		}
+	}
+	select {
+	case t.spans <- *span:
+	default:
+	}
+}
+
+func (t *otlpTracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.export()
+		case <-t.done:
+			t.export()
+			return
+		}
+	}
+}
+
+func (t *otlpTracer) export() {
+	var spans []otlpSpan
+	for n := len(t.spans); n > 0; n-- {
+		spans = append(spans, <-t.spans)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	err := otlpPost(t.endpoint, "/v1/traces", otlpTracesRequest{[]otlpResourceSpans{{
+		Resource:   otlpServiceResource,
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScopeName, Spans: spans}},
+	}}})
+	if err != nil {
+		warnf("could not export spans: %s", err)
+	}
+}
+
+// Exports any spans not yet sent.
+func (t *otlpTracer) stop() {
+	close(t.done)
+	<-t.stopped
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+// Returns the median of xs, which it sorts.
+func median(xs []float64) float64 {
+	sort.Float64s(xs)
+	n := len(xs)
+	if n%2 == 1 {
+		return xs[n/2]
+	}
+	return (xs[n/2-1] + xs[n/2]) / 2
+}
+
+// The fewest measurements outliers are looked for among, so that fits
+// without one of them still have a few degrees of freedom.
+const minOutlierMeasurements = 6
+
+// The smallest median absolute deviation of relative residuals that's
+// assumed, so that a fit that's almost exact doesn't make any measurement
+// that misses it by the few percent runs vary by an outlier. At the default
+// threshold, a measurement has to be at least 10% off to be one.
+const minRelativeMAD = 0.02
+
+// Returns the indices of the measurements that are outliers. Each is left
+// out in turn and the model fitted to the rest; it's an outlier if its
+// residual from that fit has a modified z-score above threshold: its
+// distance from the median of the rest's residuals in units of their median
+// absolute deviation. Iglewicz and Hoaglin suggest 3.5. Leaving a
+// measurement out of its own fit, and using medians, keeps the check from
+// being thrown by the outliers it's looking for. Residuals are taken
+// relative to the prediction, as throughput varies more the higher it is.
+// Measurements at the lowest and highest concurrency aren't checked, as the
+// fit without them would be extrapolating. The throughput predicted for
+// each outlier by the fit without it is returned too. The fits are made
+// with fit and the rest's weights, as the model itself is.
+func findOutliers(concurrency, throughput, weights []float64, fit fitFunc, threshold float64) (outliers []int, predicted []float64) {
+	if len(concurrency) < minOutlierMeasurements {
+		return nil, nil
+	}
+	lowest, highest := floats.Min(concurrency), floats.Max(concurrency)
+	for i, N := range concurrency {
+		if N == lowest || N == highest {
+			continue
+		}
+		c, t := withoutOutliers(concurrency, throughput, []int{i})
+		m, _ := fit(c, t, without(weights, []int{i}))
+		residuals := make([]float64, len(c))
+		for j := range c {
+			pred := m.throughputAt(c[j])
+			residuals[j] = (t[j] - pred) / pred
+		}
+		center := median(append([]float64(nil), residuals...))
+		for j, r := range residuals {
+			residuals[j] = math.Abs(r - center)
+		}
+		mad := math.Max(median(residuals), minRelativeMAD)
+
+		pred := m.throughputAt(N)
+		if z := 0.6745 * math.Abs((throughput[i]-pred)/pred-center) / mad; z > threshold {
+			outliers = append(outliers, i)
+			predicted = append(predicted, pred)
+		}
+	}
+	return outliers, predicted
+}
+
+// Returns the elements of xs other than those at the indices in excluded,
+// or nil if xs is, as weights are when there are none.
+func without(xs []float64, excluded []int) []float64 {
+	if xs == nil {
+		return nil
+	}
+	_, kept := withoutOutliers(xs, xs, excluded)
+	return kept
+}
+
+// Returns the measurements other than those at the indices in excluded.
+func withoutOutliers(concurrency, throughput []float64, excluded []int) (c, t []float64) {
+	skip := make(map[int]bool, len(excluded))
+	for _, i := range excluded {
+		skip[i] = true
+	}
+	for i := range concurrency {
+		if !skip[i] {
+			c = append(c, concurrency[i])
+			t = append(t, throughput[i])
+		}
+	}
+	return c, t
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Returns the throughput truth predicts at each level, with 1% noise, and
+// multiplied by the factor given for its index, if there is one.
+func measure(truth uslModel, levels []float64, factors map[int]float64) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	throughput := make([]float64, len(levels))
+	for i, N := range levels {
+		throughput[i] = truth.throughputAt(N) * (1 + 0.01*rng.NormFloat64())
+		if f, ok := factors[i]; ok {
+			throughput[i] *= f
+		}
+	}
+	return throughput
+}
+
+func TestFindOutliers(t *testing.T) {
+	truth := uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}
+	levels := []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48}
+	for _, tc := range []struct {
+		name    string
+		factors map[int]float64
+		want    []int
+	}{
+		{"none", nil, nil},
+		{"low", map[int]float64{5: 0.5}, []int{5}},
+		{"high", map[int]float64{5: 1.5}, []int{5}},
+		{"two", map[int]float64{3: 0.5, 7: 0.5}, []int{3, 7}},
+		// Within the minimum spread assumed, so not an outlier.
+		{"slightly low", map[int]float64{5: 0.95}, nil},
+		// The lowest level isn't checked.
+		{"lowest", map[int]float64{0: 0.5}, nil},
+	} {
+		outliers, _ := findOutliers(levels, measure(truth, levels, tc.factors), nil, fitWeightedUSL, 3.5)
+		if !reflect.DeepEqual(outliers, tc.want) {
+			t.Errorf("%s: found outliers %v, want %v", tc.name, outliers, tc.want)
+		}
+	}
+}
+
+func TestReportFitExcludesOutliers(t *testing.T) {
+	truth := uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}
+	levels := []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48}
+	throughput := measure(truth, levels, map[int]float64{5: 0.5})
+	opts := fitOptions{outlierThreshold: 3.5, excludeOutliers: true, weights: "none"}
+
+	f := reportFit(ioutil.Discard, levels, throughput, opts)
+	if !reflect.DeepEqual(f.excluded, []int{5}) {
+		t.Fatalf("excluded %v, want [5]", f.excluded)
+	}
+	if !near(f.model.maxRps(), truth.maxRps(), 0.02) {
+		t.Errorf("maxRps without the outlier is %.0f, want about %.0f", f.model.maxRps(), truth.maxRps())
+	}
+
+	opts.excludeOutliers = false
+	f = reportFit(ioutil.Discard, levels, throughput, opts)
+	if f.excluded != nil || !reflect.DeepEqual(f.outliers, []int{5}) {
+		t.Errorf("without -excludeOutliers, found outliers %v and excluded %v, want [5] and none", f.outliers, f.excluded)
+	}
+}
+
+func TestReportFitKeepsOutliersWhenTooFewWouldBeLeft(t *testing.T) {
+	truth := uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}
+	levels := []float64{1, 2, 4, 8, 16, 32}
+	throughput := measure(truth, levels, nil)
+	// A threshold this low makes every level checked an outlier, leaving
+	// only the lowest and highest.
+	opts := fitOptions{outlierThreshold: 1e-9, excludeOutliers: true, weights: "none"}
+
+	f := reportFit(ioutil.Discard, levels, throughput, opts)
+	if len(f.outliers) != len(levels)-2 {
+		t.Fatalf("found outliers %v, want all but the lowest and highest levels", f.outliers)
+	}
+	if f.excluded != nil {
+		t.Errorf("excluded %v, leaving fewer than %d measurements", f.excluded, minFitMeasurements)
+	}
+	if !near(f.model.maxRps(), truth.maxRps(), 0.05) {
+		t.Errorf("maxRps of the fit kept is %.0f, want about %.0f", f.model.maxRps(), truth.maxRps())
+	}
+}
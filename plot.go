@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	plotWidth  = 60
+	plotHeight = 20
+)
+
+// Returns the largest concurrency and throughput a plot needs to show. The
+// model is sampled at samples points across the concurrency axis.
+func plotBounds(concurrency, throughput []float64, model *uslModel, samples int) (maxX, maxY float64) {
+	maxX = 1.0
+	for _, n := range concurrency {
+		maxX = math.Max(maxX, n)
+	}
+	if model != nil {
+		// Show the model past its peak so the rollover is visible.
+		if peak := model.maxConcurrency(); !math.IsNaN(peak) && !math.IsInf(peak, 0) {
+			maxX = math.Max(maxX, math.Min(2*peak, 10*maxX))
+		}
+	}
+
+	for _, x := range throughput {
+		maxY = math.Max(maxY, x)
+	}
+	if model != nil {
+		for i := 0; i < samples; i++ {
+			maxY = math.Max(maxY, model.throughputAt(1+float64(i)*(maxX-1)/float64(samples-1)))
+		}
+	}
+	if maxY <= 0 || math.IsNaN(maxY) {
+		maxY = 1
+	}
+	return maxX, maxY
+}
+
+// Draws throughput against concurrency as text. Measured points are drawn
+// as '*' and, if model is non-nil, its predicted curve as '.'.
+func plotText(w io.Writer, concurrency, throughput []float64, model *uslModel) {
+	maxX, maxY := plotBounds(concurrency, throughput, model, plotWidth)
+	xAt := func(col int) float64 {
+		return 1 + float64(col)*(maxX-1)/(plotWidth-1)
+	}
+
+	grid := make([][]byte, plotHeight)
+	for i := range grid {
+		grid[i] = []byte(strings.Repeat(" ", plotWidth))
+	}
+	plot := func(x, y float64, c byte) {
+		if math.IsNaN(y) || y < 0 {
+			return
+		}
+		col := 0
+		if maxX > 1 {
+			col = int(math.Floor((x-1)/(maxX-1)*(plotWidth-1) + 0.5))
+		}
+		row := plotHeight - 1 - int(math.Floor(y/maxY*(plotHeight-1)+0.5))
+		if col >= 0 && col < plotWidth && row >= 0 && row < plotHeight {
+			grid[row][col] = c
+		}
+	}
+
+	if model != nil {
+		for col := 0; col < plotWidth; col++ {
+			x := xAt(col)
+			plot(x, model.throughputAt(x), '.')
+		}
+	}
+	for i, n := range concurrency {
+		plot(n, throughput[i], '*')
+	}
+
+	for i, row := range grid {
+		label := ""
+		switch i {
+		case 0:
+			label = fmt.Sprintf("%.0f", maxY)
+		case plotHeight / 2:
+			label = fmt.Sprintf("%.0f", maxY/2)
+		case plotHeight - 1:
+			label = "0"
+		}
+		fmt.Fprintf(w, "%10s |%s\n", label, row)
+	}
+	fmt.Fprintf(w, "%10s +%s\n", "", strings.Repeat("-", plotWidth))
+	right := fmt.Sprintf("%.0f", maxX)
+	fmt.Fprintf(w, "%10s  1%s%s\n", "", strings.Repeat(" ", plotWidth-1-len(right)), right)
+	fmt.Fprintf(w, "%10s  %s\n", "", "concurrency (* measured, . model)")
+}
+
+// The size of an SVG plot and the margin left around its axes for labels.
+const (
+	svgWidth  = 640
+	svgHeight = 400
+	svgMargin = 60
+)
+
+// Draws throughput against concurrency as an SVG image. Measured points are
+// drawn as dots and, if model is non-nil, its predicted curve as a line,
+// with a legend saying which is which.
+func plotSVG(w io.Writer, concurrency, throughput []float64, model *uslModel) {
+	const samples = 200
+	maxX, maxY := plotBounds(concurrency, throughput, model, samples)
+	px := func(x float64) float64 {
+		if maxX <= 1 {
+			return svgMargin
+		}
+		return svgMargin + (x-1)/(maxX-1)*(svgWidth-2*svgMargin)
+	}
+	py := func(y float64) float64 {
+		return svgHeight - svgMargin - y/maxY*(svgHeight-2*svgMargin)
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n", svgWidth, svgHeight, svgWidth, svgHeight)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="white"/>`+"\n", svgWidth, svgHeight)
+	fmt.Fprintf(w, `<path d="M%d %d V%d H%d" fill="none" stroke="black"/>`+"\n", svgMargin, svgMargin, svgHeight-svgMargin, svgWidth-svgMargin)
+	for _, y := range []float64{0, maxY / 2, maxY} {
+		fmt.Fprintf(w, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%.0f</text>`+"\n", svgMargin-6, py(y), y)
+	}
+	for _, x := range []float64{1, maxX} {
+		fmt.Fprintf(w, `<text x="%.1f" y="%d" text-anchor="middle">%.0f</text>`+"\n", px(x), svgHeight-svgMargin+18, x)
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">concurrency</text>`+"\n", svgWidth/2, svgHeight-svgMargin/3)
+	fmt.Fprintf(w, `<text transform="translate(%d %d) rotate(-90)" text-anchor="middle">throughput (rps)</text>`+"\n", svgMargin/4, svgHeight/2)
+
+	if model != nil {
+		var points []string
+		for i := 0; i < samples; i++ {
+			x := 1 + float64(i)*(maxX-1)/(samples-1)
+			y := model.throughputAt(x)
+			if math.IsNaN(y) || y < 0 {
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", px(x), py(math.Min(y, maxY))))
+		}
+		fmt.Fprintf(w, `<polyline points="%s" fill="none" stroke="steelblue" stroke-width="2"/>`+"\n", strings.Join(points, " "))
+	}
+	for i, n := range concurrency {
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="4" fill="darkorange"><title>%.0f: %.0f rps</title></circle>`+"\n", px(n), py(throughput[i]), n, throughput[i])
+	}
+
+	legendX, legendY := svgWidth-svgMargin-100, svgMargin/2
+	if len(concurrency) > 0 {
+		fmt.Fprintf(w, `<circle cx="%d" cy="%d" r="4" fill="darkorange"/>`+"\n", legendX+10, legendY)
+		fmt.Fprintf(w, `<text x="%d" y="%d" dominant-baseline="middle">measured</text>`+"\n", legendX+26, legendY)
+		legendY += 18
+	}
+	if model != nil {
+		fmt.Fprintf(w, `<path d="M%d %d h20" stroke="steelblue" stroke-width="2"/>`+"\n", legendX, legendY)
+		fmt.Fprintf(w, `<text x="%d" y="%d" dominant-baseline="middle">model</text>`+"\n", legendX+26, legendY)
+	}
+	fmt.Fprintln(w, "</svg>")
+}
+
+// Returns whether filename ends in .svg, the only format plots are saved
+// in: a PNG or any other raster image would need a font to label its axes
+// with, short of vendoring a plotting library.
+func isSVG(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".svg")
+}
+
+// Writes a plot of throughput against concurrency to filename as an SVG.
+func writePlot(filename string, concurrency, throughput []float64, model *uslModel) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	plotSVG(f, concurrency, throughput, model)
+	return f.Close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// How often the progress line is redrawn.
+const progressInterval = 500 * time.Millisecond
+
+// progressLine keeps a line of the terminal updated with how the current
+// level is going, so that a long run doesn't look like it has hung. Each
+// level's line is left in place when it finishes.
+type progressLine struct {
+	w       io.Writer
+	live    *liveStats
+	level   int
+	start   time.Time
+	done    chan struct{}
+	stopped chan struct{}
+
+	// The errors when the level started, and the requests at the last
+	// redraw.
+	startErrors  uint64
+	lastRequests uint64
+	lastTime     time.Time
+	rps          float64
+}
+
+// Reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Starts redrawing the progress of level until finish is called.
+func startProgress(w io.Writer, live *liveStats, level int) *progressLine {
+	now := time.Now()
+	requests := atomic.LoadUint64(&live.requests)
+	p := &progressLine{
+		w:            w,
+		live:         live,
+		level:        level,
+		start:        now,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		startErrors:  atomic.LoadUint64(&live.errors),
+		lastRequests: requests,
+		lastTime:     now,
+	}
+	go p.run()
+	return p
+}
+
+func (p *progressLine) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.draw(now)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Redraws the line with the time since the level started, the rate of
+// successful requests since the last redraw and the errors so far.
+func (p *progressLine) draw(now time.Time) {
+	requests := atomic.LoadUint64(&p.live.requests)
+	errors := atomic.LoadUint64(&p.live.errors)
+	if elapsed := now.Sub(p.lastTime).Seconds(); elapsed > 0 {
+		p.rps = float64(requests-p.lastRequests) / elapsed
+	}
+	p.lastRequests, p.lastTime = requests, now
+	fmt.Fprintf(p.w, "\r\033[Kconcurrency %d: %s elapsed, %.0f rps, %d errors",
+		p.level, now.Sub(p.start).Truncate(100*time.Millisecond), p.rps, errors-p.startErrors)
+}
+
+// Stops redrawing, leaving the line with the level's overall throughput and
+// errors.
+func (p *progressLine) finish(r levelResult) {
+	close(p.done)
+	<-p.stopped
+	fmt.Fprintf(p.w, "\r\033[Kconcurrency %d: %s elapsed, %.1f rps, %d errors\n",
+		p.level, r.duration.Truncate(100*time.Millisecond), r.throughput, r.errors)
+}
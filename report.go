@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// jsonFloat is a float64 that's encoded as null if it's NaN or infinite,
+// which JSON can't represent, as when the model couldn't be fitted.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+// runReport is the machine readable account of a run, written with
+// -outputFormat json.
+type runReport struct {
+	Levels []levelReport `json:"levels"`
+	modelReport
+	// With -replicates, how much the throughput at each level varied.
+	Replicates []replicateStats `json:"replicates,omitempty"`
+	// With -fitLatency, the model fitted to latency instead.
+	LatencyFit *latencyFit `json:"latencyFit,omitempty"`
+}
+
+// modelCoefficients are a model's coefficients as reported, as null if
+// they aren't finite, as they may not be if it couldn't be fitted.
+type modelCoefficients struct {
+	Sigma  jsonFloat `json:"sigma"`
+	Kappa  jsonFloat `json:"kappa"`
+	Lambda jsonFloat `json:"lambda"`
+}
+
+func newModelCoefficients(m uslModel) modelCoefficients {
+	return modelCoefficients{jsonFloat(m.Sigma), jsonFloat(m.Kappa), jsonFloat(m.Lambda)}
+}
+
+func (c modelCoefficients) model() uslModel {
+	return uslModel{Sigma: float64(c.Sigma), Kappa: float64(c.Kappa), Lambda: float64(c.Lambda)}
+}
+
+// modelReport is the machine readable account of a fitted model, which run
+// and fit report under the same names.
+type modelReport struct {
+	modelCoefficients
+	MaxConcurrency jsonFloat `json:"maxConcurrency"`
+	MaxRps         jsonFloat `json:"maxRps"`
+	// The concurrency at which throughput peaks, before maxConcurrency
+	// rounds it to whichever whole number gives more.
+	Optimal jsonFloat `json:"optimalConcurrency"`
+	// The mean latency the model predicts at maxConcurrency, in
+	// milliseconds.
+	MeanLatencyAtMax jsonFloat `json:"meanLatencyAtMaxConcurrency"`
+	// How well the model fits the throughput measured at each level.
+	RSquared jsonFloat `json:"rSquared"`
+	RMSE     jsonFloat `json:"rmse"`
+	// How well the model predicts levels left out of its fit.
+	CrossValidation *crossValidation `json:"crossValidation,omitempty"`
+	// With -bootstrap, 95% confidence intervals for the model.
+	Intervals *modelIntervals `json:"confidenceIntervals,omitempty"`
+	// Whether maxConcurrency is far outside the levels measured, which means
+	// the model shouldn't be trusted.
+	Extrapolating bool `json:"extrapolating,omitempty"`
+	// How Amdahl's law, the model without crosstalk, compares.
+	Amdahl *modelComparison `json:"amdahl,omitempty"`
+	// If the model couldn't be fitted, the peak measured, which
+	// maxConcurrency and maxRps are then taken from.
+	Empirical *empiricalPeak `json:"empirical,omitempty"`
+}
+
+// levelReport is what was measured at a single concurrency level.
+type levelReport struct {
+	Concurrency int `json:"concurrency"`
+	// Which run of the level this was, from 0, with -replicates.
+	Replicate  int     `json:"replicate,omitempty"`
+	Throughput float64 `json:"throughput"`
+	Errors     int     `json:"errors"`
+	Truncated  int     `json:"truncated"`
+	// The throughput measured less that the model predicts.
+	Residual jsonFloat `json:"residual"`
+	// The mean latency the model predicts, by Little's law, in
+	// milliseconds.
+	PredictedLatency jsonFloat `json:"predictedMeanLatency"`
+	// Whether the throughput was an outlier from the fit.
+	Outlier bool `json:"outlier,omitempty"`
+	// How long the level took, in seconds.
+	Duration float64       `json:"duration"`
+	Latency  latencyReport `json:"latency"`
+	// Requests that succeeded in each second of the level.
+	PerSecond []int `json:"perSecond"`
+	// The t-digest of latencies, with -latencySketch tdigest, so that it can
+	// be merged with those of other runs.
+	LatencyDigest []centroid `json:"latencyDigest,omitempty"`
+	// Errors by category, such as "timeout".
+	ErrorsByCategory map[string]int `json:"errorsByCategory,omitempty"`
+	// Responses by status class, such as "5xx".
+	StatusClasses map[string]int `json:"statusClasses,omitempty"`
+	// Only given when more than one type of request was made.
+	ThroughputByType map[string]float64 `json:"throughputByType,omitempty"`
+}
+
+// Describes the model fitted to the measurements, as reportFit found it.
+func newModelReport(concurrency, throughput []float64, f fitResult) modelReport {
+	m := f.model
+	r := modelReport{
+		modelCoefficients: newModelCoefficients(m),
+		MaxConcurrency:    jsonFloat(m.maxConcurrency()),
+		Optimal:           jsonFloat(m.optimalConcurrency()),
+		MaxRps:            jsonFloat(m.maxRps()),
+		MeanLatencyAtMax:  jsonFloat(m.latencyAt(m.maxConcurrency()) * 1000),
+		Extrapolating:     f.extrapolating != "",
+		Amdahl:            f.amdahl,
+		Empirical:         f.empirical,
+		CrossValidation:   f.crossValidation,
+	}
+	fit := newGoodnessOfFit(concurrency, throughput, m)
+	r.RSquared, r.RMSE = jsonFloat(fit.rSquared), jsonFloat(fit.rmse)
+	if f.empirical != nil {
+		r.MaxConcurrency, r.MaxRps = jsonFloat(f.empirical.Concurrency), jsonFloat(f.empirical.Throughput)
+	}
+	return r
+}
+
+func newRunReport(types []requestType, results []levelResult, f fitResult) runReport {
+	m := f.model
+	var concurrency, throughput []float64
+	for _, result := range results {
+		concurrency = append(concurrency, float64(result.concurrency))
+		throughput = append(throughput, result.throughput)
+	}
+	r := runReport{
+		Levels:      make([]levelReport, len(results)),
+		modelReport: newModelReport(concurrency, throughput, f),
+		Replicates:  newReplicateStats(results),
+	}
+
+	for i, result := range results {
+		level := levelReport{
+			Concurrency:      result.concurrency,
+			Replicate:        result.replicate,
+			Throughput:       result.throughput,
+			Errors:           result.errors,
+			Truncated:        result.truncated,
+			Residual:         jsonFloat(result.throughput - m.throughputAt(float64(result.concurrency))),
+			PredictedLatency: jsonFloat(m.latencyAt(float64(result.concurrency)) * 1000),
+			Duration:         result.duration.Seconds(),
+			Latency:          newLatencyReport(result.latency),
+			PerSecond:        result.perSecond,
+		}
+		if d, ok := result.latency.(*tdigest); ok {
+			level.LatencyDigest = d.digest()
+		}
+		for j, n := range result.errorsByCategory {
+			if n > 0 {
+				if level.ErrorsByCategory == nil {
+					level.ErrorsByCategory = make(map[string]int)
+				}
+				level.ErrorsByCategory[errorCategory(j).String()] = n
+			}
+		}
+		for j, n := range result.responses.statusClasses {
+			if n > 0 {
+				if level.StatusClasses == nil {
+					level.StatusClasses = make(map[string]int)
+				}
+				level.StatusClasses[fmt.Sprintf("%dxx", j+1)] = n
+			}
+		}
+		if len(types) > 1 {
+			level.ThroughputByType = make(map[string]float64, len(types))
+			for j, t := range types {
+				level.ThroughputByType[t.name] = result.throughputByType[j]
+			}
+		}
+		r.Levels[i] = level
+	}
+	return r
+}
+
+// replicateStats describes how the throughput at a concurrency level varied
+// across its replicates.
+type replicateStats struct {
+	Concurrency int     `json:"concurrency"`
+	Replicates  int     `json:"replicates"`
+	Mean        float64 `json:"mean"`
+	Stddev      float64 `json:"stddev"`
+}
+
+// Returns the mean and sample standard deviation of the throughput at each
+// level that was run more than once, in the order the levels were run.
+func newReplicateStats(results []levelResult) []replicateStats {
+	var stats []replicateStats
+	index := make(map[int]int)
+	var throughputs [][]float64
+	for _, result := range results {
+		i, ok := index[result.concurrency]
+		if !ok {
+			i = len(stats)
+			index[result.concurrency] = i
+			stats = append(stats, replicateStats{Concurrency: result.concurrency})
+			throughputs = append(throughputs, nil)
+		}
+		throughputs[i] = append(throughputs[i], result.throughput)
+	}
+
+	var replicated []replicateStats
+	for i, s := range stats {
+		xs := throughputs[i]
+		if len(xs) < 2 {
+			continue
+		}
+		s.Replicates = len(xs)
+		s.Mean, s.Stddev = stat.MeanStdDev(xs, nil)
+		replicated = append(replicated, s)
+	}
+	return replicated
+}
+
+func printReplicateStats(out io.Writer, stats []replicateStats) {
+	for _, s := range stats {
+		fmt.Fprintf(out, "concurrency %d: throughput mean %.0f, stddev %.0f (%.1f%%) over %d replicates\n",
+			s.Concurrency, s.Mean, s.Stddev, 100*s.Stddev/s.Mean, s.Replicates)
+	}
+}
+
+// latencyReport summarizes the latency of successful requests, in
+// milliseconds.
+type latencyReport struct {
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p999"`
+}
+
+func newLatencyReport(h latencySketch) latencyReport {
+	ms := func(d time.Duration) float64 {
+		return d.Seconds() * 1000
+	}
+	return latencyReport{
+		Mean:   ms(h.mean()),
+		Stddev: ms(h.stddev()),
+		Min:    ms(h.min()),
+		Max:    ms(h.max()),
+		P50:    ms(h.valueAtPercentile(50)),
+		P90:    ms(h.valueAtPercentile(90)),
+		P99:    ms(h.valueAtPercentile(99)),
+		P999:   ms(h.valueAtPercentile(99.9)),
+	}
+}
+
+func writeReport(w io.Writer, r interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Writes r as markdown: a table of the measurements at each level followed
+// by the fitted model, for pasting into pull requests and the like. If the
+// model couldn't be fitted, the peak measured is given instead, without the
+// predictions a model would make.
+func writeMarkdownReport(w io.Writer, r runReport) {
+	if r.Empirical != nil {
+		fmt.Fprintln(w, "| Concurrency | Throughput (rps) | Errors | Mean (ms) | p50 (ms) | p99 (ms) |")
+		fmt.Fprintln(w, "|------------:|-----------------:|-------:|----------:|---------:|---------:|")
+		for _, level := range r.Levels {
+			fmt.Fprintf(w, "| %d | %.1f | %d | %.3f | %.3f | %.3f |\n",
+				level.Concurrency, level.Throughput, level.Errors, level.Latency.Mean, level.Latency.P50, level.Latency.P99)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "**maxRps (empirical)** %.0f at **maxConcurrency (empirical)** %.0f, the peak measured, as the model couldn't be fitted\n",
+			r.Empirical.Throughput, r.Empirical.Concurrency)
+		return
+	}
+
+	fmt.Fprintln(w, "| Concurrency | Throughput (rps) | Errors | Mean (ms) | Predicted mean (ms) | p50 (ms) | p99 (ms) |")
+	fmt.Fprintln(w, "|------------:|-----------------:|-------:|----------:|--------------------:|---------:|---------:|")
+	for _, level := range r.Levels {
+		fmt.Fprintf(w, "| %d | %.1f | %d | %.3f | %.3f | %.3f | %.3f |\n",
+			level.Concurrency, level.Throughput, level.Errors, level.Latency.Mean, float64(level.PredictedLatency), level.Latency.P50, level.Latency.P99)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "**maxRps** %.0f at **maxConcurrency** %.0f (sigma %.6g, kappa %.6g, lambda %.6g; R² %.4f, RMSE %.0f)\n",
+		float64(r.MaxRps), float64(r.MaxConcurrency), float64(r.Sigma), float64(r.Kappa), float64(r.Lambda), float64(r.RSquared), float64(r.RMSE))
+}
+
+// savedRun is a runReport along with when and how the run was made, so that
+// it can be reproduced.
+type savedRun struct {
+	Started     time.Time         `json:"started"`
+	CommandLine []string          `json:"commandLine"`
+	Flags       map[string]string `json:"flags"`
+	runReport
+}
+
+// Writes r to filename as JSON, along with the value of every flag in flags
+// and when the run started.
+func writeSavedRun(filename string, started time.Time, flags *flag.FlagSet, r runReport) error {
+	saved := savedRun{
+		Started:     started,
+		CommandLine: os.Args,
+		Flags:       make(map[string]string),
+		runReport:   r,
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		saved.Flags[f.Name] = f.Value.String()
+	})
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(saved); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// runMetadata describes how a run was made, for the HTML report.
+// TimePerLevel is 0 if levels ran for different times.
+type runMetadata struct {
+	Address      string
+	Started      time.Time
+	TimePerLevel time.Duration
+	CommandLine  string
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>http-max-rps: {{.Meta.Address}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+code { background: #f4f4f4; padding: 0.1em 0.3em; }
+</style>
+</head>
+<body>
+<h1>http-max-rps</h1>
+<p>
+Target <code>{{.Meta.Address}}</code>, started {{.Meta.Started.Format "2006-01-02 15:04:05 MST"}},
+{{if .Meta.TimePerLevel}}{{.Meta.TimePerLevel}} per level{{else}}levels run for times in proportion to their concurrency{{end}}.<br>
+<code>{{.Meta.CommandLine}}</code>
+</p>
+
+{{with .Report.Empirical}}<h2>Peak measured</h2>
+<p>The model couldn't be fitted, so the highest throughput measured is reported instead.</p>
+<table>
+<tr><td>maxRps (empirical)</td><td>{{printf "%.0f" .Throughput}}</td></tr>
+<tr><td>maxConcurrency (empirical)</td><td>{{printf "%.0f" .Concurrency}}</td></tr>
+</table>
+{{else}}<h2>Model</h2>
+<table>
+<tr><td>maxRps</td><td>{{printf "%.0f" .Report.MaxRps}}</td></tr>
+<tr><td>maxConcurrency</td><td>{{printf "%.0f" .Report.MaxConcurrency}}</td></tr>
+<tr><td>mean latency at maxConcurrency (ms)</td><td>{{printf "%.3f" .Report.MeanLatencyAtMax}}</td></tr>
+<tr><td>sigma (the overhead of contention)</td><td>{{printf "%.6g" .Report.Sigma}}</td></tr>
+<tr><td>kappa (the overhead of crosstalk)</td><td>{{printf "%.6g" .Report.Kappa}}</td></tr>
+<tr><td>lambda (unloaded performance)</td><td>{{printf "%.6g" .Report.Lambda}}</td></tr>
+</table>
+{{end}}
+
+{{.Plot}}
+
+<h2>Measurements</h2>
+<table>
+<tr><th>concurrency</th><th>throughput (rps)</th><th>errors</th><th>mean (ms)</th>{{if not .Report.Empirical}}<th>predicted mean (ms)</th>{{end}}<th>stddev (ms)</th><th>min (ms)</th><th>max (ms)</th><th>p50 (ms)</th><th>p90 (ms)</th><th>p99 (ms)</th><th>p99.9 (ms)</th></tr>
+{{range .Report.Levels}}<tr><td>{{.Concurrency}}</td><td>{{printf "%.1f" .Throughput}}</td><td>{{.Errors}}</td><td>{{printf "%.3f" .Latency.Mean}}</td>{{if not $.Report.Empirical}}<td>{{printf "%.3f" .PredictedLatency}}</td>{{end}}<td>{{printf "%.3f" .Latency.Stddev}}</td><td>{{printf "%.3f" .Latency.Min}}</td><td>{{printf "%.3f" .Latency.Max}}</td><td>{{printf "%.3f" .Latency.P50}}</td><td>{{printf "%.3f" .Latency.P90}}</td><td>{{printf "%.3f" .Latency.P99}}</td><td>{{printf "%.3f" .Latency.P999}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Writes a self-contained HTML page describing the run: its metadata, the
+// fitted model, a plot of the measurements against it, and the throughput
+// and latency at each level. If the model couldn't be fitted, the peak
+// measured takes its place and only the measurements are plotted.
+func writeHTMLReport(filename string, meta runMetadata, r runReport) error {
+	var concurrency, throughput []float64
+	for _, level := range r.Levels {
+		concurrency = append(concurrency, float64(level.Concurrency))
+		throughput = append(throughput, level.Throughput)
+	}
+	var plot bytes.Buffer
+	if r.Empirical != nil {
+		plotSVG(&plot, concurrency, throughput, nil)
+	} else {
+		model := r.model()
+		plotSVG(&plot, concurrency, throughput, &model)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = htmlReportTemplate.Execute(f, struct {
+		Meta   runMetadata
+		Report runReport
+		Plot   template.HTML
+	}{meta, r, template.HTML(plot.String())})
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
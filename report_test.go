@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Throughput that's zero at every level, as when every request failed,
+// can't be fitted, so the peak measured is reported in place of the model,
+// in every format.
+func TestReportFitFallsBackToEmpiricalPeak(t *testing.T) {
+	levels := []float64{1, 2, 4, 8, 16}
+	throughput := []float64{0, 0, 0, 0, 0}
+	f := reportFit(ioutil.Discard, levels, throughput, fitOptions{weights: "none"})
+	if f.empirical == nil {
+		t.Fatalf("got model %+v, want the empirical peak", f.model)
+	}
+	if *f.empirical != (empiricalPeak{Concurrency: 1, Throughput: 0}) {
+		t.Errorf("got empirical peak %+v, want 0 rps at 1", *f.empirical)
+	}
+	if !math.IsNaN(f.model.Sigma) || !math.IsNaN(f.model.maxRps()) {
+		t.Errorf("got model %+v, want NaN coefficients", f.model)
+	}
+	if c, rps := f.peak(); c != 1 || rps != 0 {
+		t.Errorf("got peak %g rps at %g, want 0 at 1", rps, c)
+	}
+
+	newSketch, _ := latencySketchMaker("hdr")
+	var results []levelResult
+	for i, N := range levels {
+		results = append(results, levelResult{concurrency: int(N), throughput: throughput[i], latency: newSketch()})
+	}
+	r := newRunReport([]requestType{{name: "GET /"}}, results, f)
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"sigma", "kappa", "lambda", "rSquared", "rmse"} {
+		if decoded[key] != nil {
+			t.Errorf("JSON report has %s %v, want null", key, decoded[key])
+		}
+	}
+	if decoded["maxRps"] != 0.0 || decoded["maxConcurrency"] != 1.0 || decoded["empirical"] == nil {
+		t.Errorf("JSON report has maxRps %v at %v and empirical %v, want the peak measured", decoded["maxRps"], decoded["maxConcurrency"], decoded["empirical"])
+	}
+	for _, level := range decoded["levels"].([]interface{}) {
+		if residual := level.(map[string]interface{})["residual"]; residual != nil {
+			t.Errorf("JSON report has residual %v, want null", residual)
+		}
+	}
+
+	buf.Reset()
+	writeMarkdownReport(&buf, r)
+	markdown := buf.String()
+	if !strings.Contains(markdown, "**maxRps (empirical)** 0 at **maxConcurrency (empirical)** 1") || strings.Contains(markdown, "Predicted") || strings.Contains(markdown, "sigma") {
+		t.Errorf("markdown report doesn't give just the empirical peak:\n%s", markdown)
+	}
+}
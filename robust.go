@@ -0,0 +1,67 @@
+package main
+
+import "math"
+
+// The Huber loss treats residuals up to huberK robust standard deviations
+// as least squares does, and larger ones as if they were only linear, so
+// that a single wild level can't drag the fit towards it. 1.345 keeps 95%
+// of the efficiency of least squares on normally distributed residuals.
+const (
+	huberK             = 1.345
+	maxHuberIterations = 20
+)
+
+// fitRobustUSL is fitWeightedUSL with the Huber loss instead of squared
+// residuals. Weights may be nil, as for fitWeightedUSL.
+func fitRobustUSL(concurrency, throughput, weights []float64) (uslModel, error) {
+	return fitRobust(fitWeightedUSL, concurrency, throughput, weights)
+}
+
+// Returns the scale of residuals, their median absolute deviation scaled
+// to estimate the standard deviation of normally distributed residuals.
+func residualScale(residuals []float64) float64 {
+	abs := make([]float64, len(residuals))
+	for i, r := range residuals {
+		abs[i] = math.Abs(r)
+	}
+	return 1.4826 * median(abs)
+}
+
+// fitRobust fits a model with fit, a weighted least squares fit, using the
+// Huber loss instead of squared residuals. It's found by iteratively
+// reweighted least squares: each pass downweights the measurements whose
+// residuals from the last pass were large.
+func fitRobust(fit fitFunc, concurrency, throughput, weights []float64) (uslModel, error) {
+	m, err := fit(concurrency, throughput, weights)
+	huber := make([]float64, len(concurrency))
+	residuals := make([]float64, len(concurrency))
+	for iteration := 0; iteration < maxHuberIterations; iteration++ {
+		for i, N := range concurrency {
+			residuals[i] = math.Abs(throughput[i] - m.throughputAt(N))
+		}
+		scale := residualScale(residuals)
+		if scale == 0 {
+			break
+		}
+
+		changed := false
+		for i, r := range residuals {
+			w := 1.0
+			if r > huberK*scale {
+				w = huberK * scale / r
+			}
+			if weights != nil {
+				w *= weights[i]
+			}
+			if math.Abs(w-huber[i]) > 1e-3 {
+				changed = true
+			}
+			huber[i] = w
+		}
+		if !changed {
+			break
+		}
+		m, err = fit(concurrency, throughput, huber)
+	}
+	return m, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFitRobustUSL(t *testing.T) {
+	truth := uslModel{Sigma: 0.05, Kappa: 0.0005, Lambda: 800}
+	levels := []float64{1, 2, 4, 6, 8, 12, 16, 24, 32, 48}
+	for _, tc := range []struct {
+		name string
+		// Factors the throughput at each index is multiplied by.
+		outliers map[int]float64
+		weights  []float64
+	}{
+		{name: "no outliers"},
+		{name: "one low", outliers: map[int]float64{5: 0.5}},
+		{name: "one high", outliers: map[int]float64{3: 1.6}},
+		{name: "two low", outliers: map[int]float64{4: 0.6, 7: 0.5}},
+		{name: "weighted", outliers: map[int]float64{5: 0.5}, weights: []float64{1, 1, 1, 1, 1, 2, 2, 2, 2, 2}},
+	} {
+		rng := rand.New(rand.NewSource(1))
+		throughput := make([]float64, len(levels))
+		for i, N := range levels {
+			throughput[i] = truth.throughputAt(N) * (1 + 0.01*rng.NormFloat64())
+			if f, ok := tc.outliers[i]; ok {
+				throughput[i] *= f
+			}
+		}
+
+		robust, _ := fitRobustUSL(levels, throughput, tc.weights)
+		plain, _ := fitWeightedUSL(levels, throughput, tc.weights)
+		robustErr := math.Abs(robust.maxRps()-truth.maxRps()) / truth.maxRps()
+		plainErr := math.Abs(plain.maxRps()-truth.maxRps()) / truth.maxRps()
+		if robustErr > 0.03 {
+			t.Errorf("%s: robust maxRps %.0f is %.1f%% off the true %.0f", tc.name, robust.maxRps(), 100*robustErr, truth.maxRps())
+		}
+		if len(tc.outliers) > 0 && robustErr > plainErr {
+			t.Errorf("%s: robust maxRps %.0f is further off the true %.0f than least squares' %.0f", tc.name, robust.maxRps(), truth.maxRps(), plain.maxRps())
+		}
+	}
+}
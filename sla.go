@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The status to exit with if a run doesn't meet -minMaxRps,
+// -failIfMaxRpsBelow or -maxErrorRate.
+const exitSLAViolated = 3
+
+// slaCheck is the outcome of checking a run against one of its thresholds.
+type slaCheck struct {
+	name string
+	// Why the check failed, or empty if it passed.
+	failure string
+}
+
+// Returns the error rate across results, and whether any requests were made.
+func errorRate(results ...levelResult) (float64, bool) {
+	var requests, errors int
+	for _, r := range results {
+		requests += r.requests + r.errors
+		errors += r.errors
+	}
+	if requests == 0 {
+		return 0, false
+	}
+	return float64(errors) / float64(requests), true
+}
+
+// slaThresholds are what a run must meet. A zero minimum or a negative
+// maxErrorRate disables that check.
+type slaThresholds struct {
+	minMaxRps float64
+	// The minimum maxRps given by -failIfMaxRpsBelow, and how it was given.
+	failIfMaxRpsBelow     float64
+	failIfMaxRpsBelowFlag string
+	maxErrorRate          float64
+}
+
+// Parses the value of -failIfMaxRpsBelow, which is either a throughput or a
+// percentage of the maxRps of baseline, such as 95%.
+func parseMaxRpsThreshold(s string, baseline *runReport) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, err
+		}
+		if baseline == nil {
+			return 0, fmt.Errorf("a percentage needs a -baseline")
+		}
+		return percent / 100 * float64(baseline.MaxRps), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Checks maxRps, as fitted or measured, and the errors measured across
+// results against the thresholds that are set.
+func checkSLA(results []levelResult, maxRps float64, t slaThresholds) []slaCheck {
+	var checks []slaCheck
+	// NaN compares false, so a failed fit fails these checks too.
+	if t.minMaxRps > 0 {
+		c := slaCheck{name: "minMaxRps"}
+		if !(maxRps >= t.minMaxRps) {
+			c.failure = fmt.Sprintf("maxRps %f is below -minMaxRps %g", maxRps, t.minMaxRps)
+		}
+		checks = append(checks, c)
+	}
+	if t.failIfMaxRpsBelow > 0 {
+		c := slaCheck{name: "failIfMaxRpsBelow"}
+		if !(maxRps >= t.failIfMaxRpsBelow) {
+			c.failure = fmt.Sprintf("maxRps %f is below -failIfMaxRpsBelow %s (%.0f)", maxRps, t.failIfMaxRpsBelowFlag, t.failIfMaxRpsBelow)
+		}
+		checks = append(checks, c)
+	}
+	if t.maxErrorRate >= 0 {
+		c := slaCheck{name: "maxErrorRate"}
+		if rate, ok := errorRate(results...); ok && rate > t.maxErrorRate {
+			c.failure = fmt.Sprintf("error rate %.4f is above -maxErrorRate %g", rate, t.maxErrorRate)
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
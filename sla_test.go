@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseMaxRpsThreshold(t *testing.T) {
+	baseline := &runReport{modelReport: modelReport{MaxRps: 2000}}
+	for _, tc := range []struct {
+		s        string
+		baseline *runReport
+		want     float64
+		err      bool
+	}{
+		{s: "1500", want: 1500},
+		{s: "1500", baseline: baseline, want: 1500},
+		{s: "95%", baseline: baseline, want: 1900},
+		{s: "95%", err: true},
+		{s: "lots", err: true},
+		{s: "x%", baseline: baseline, err: true},
+	} {
+		got, err := parseMaxRpsThreshold(tc.s, tc.baseline)
+		if (err != nil) != tc.err || (!tc.err && got != tc.want) {
+			t.Errorf("%s (baseline %t): got %g, %v; want %g, error %t", tc.s, tc.baseline != nil, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestCheckSLA(t *testing.T) {
+	results := []levelResult{{requests: 90, errors: 10}, {requests: 100}}
+	for _, tc := range []struct {
+		name       string
+		maxRps     float64
+		thresholds slaThresholds
+		// The names of the checks that fail.
+		failed []string
+		checks int
+	}{
+		{name: "none set", maxRps: 1000, thresholds: slaThresholds{maxErrorRate: -1}},
+		{name: "all met", maxRps: 1000, thresholds: slaThresholds{minMaxRps: 900, failIfMaxRpsBelow: 900, maxErrorRate: 0.05}, checks: 3},
+		{name: "minMaxRps", maxRps: 1000, thresholds: slaThresholds{minMaxRps: 1100, maxErrorRate: -1}, failed: []string{"minMaxRps"}, checks: 1},
+		{name: "failIfMaxRpsBelow", maxRps: 1000, thresholds: slaThresholds{failIfMaxRpsBelow: 1100, failIfMaxRpsBelowFlag: "110%", maxErrorRate: -1}, failed: []string{"failIfMaxRpsBelow"}, checks: 1},
+		// 10 errors in 200 requests.
+		{name: "maxErrorRate", maxRps: 1000, thresholds: slaThresholds{maxErrorRate: 0.04}, failed: []string{"maxErrorRate"}, checks: 1},
+		{name: "no errors allowed", maxRps: 1000, thresholds: slaThresholds{maxErrorRate: 0}, failed: []string{"maxErrorRate"}, checks: 1},
+		{name: "failed fit", maxRps: math.NaN(), thresholds: slaThresholds{minMaxRps: 1, failIfMaxRpsBelow: 1, maxErrorRate: -1}, failed: []string{"minMaxRps", "failIfMaxRpsBelow"}, checks: 2},
+	} {
+		checks := checkSLA(results, tc.maxRps, tc.thresholds)
+		var failed []string
+		for _, c := range checks {
+			if c.failure != "" {
+				failed = append(failed, c.name)
+			}
+		}
+		if len(checks) != tc.checks || !reflect.DeepEqual(failed, tc.failed) {
+			t.Errorf("%s: got %d checks, with %v failing; want %d, with %v failing", tc.name, len(checks), failed, tc.checks, tc.failed)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// The most a StatsD packet should carry so as not to be fragmented.
+const statsdMaxPacket = 1432
+
+// statsdClient streams the progress of a run to a StatsD server: once a
+// second it sends the requests and errors since the last flush and the
+// current concurrency, along with a sample of request latencies.
+type statsdClient struct {
+	conn       net.Conn
+	prefix     string
+	sampleRate float64
+	live       *liveStats
+	timings    chan time.Duration
+	done       chan struct{}
+	stopped    chan struct{}
+
+	// What was sent by the last flush, to send only the difference.
+	requests uint64
+	errors   uint64
+}
+
+func newStatsdClient(addr, prefix string, sampleRate float64, live *liveStats) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &statsdClient{
+		conn:       conn,
+		prefix:     prefix,
+		sampleRate: sampleRate,
+		live:       live,
+		timings:    make(chan time.Duration, 10000),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Samples the latency of a successful request. Latencies are dropped rather
+// than holding up the worker if the client falls behind.
+func (c *statsdClient) timing(latency time.Duration) {
+	if c.sampleRate < 1 && rand.Float64() >= c.sampleRate {
+		return
+	}
+	select {
+	case c.timings <- latency:
+	default:
+	}
+}
+
+func (c *statsdClient) run() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+// Sends what's happened since the last flush, batching lines into as few
+// packets as it can.
+func (c *statsdClient) flush() {
+	var packet bytes.Buffer
+	send := func(line string) {
+		if packet.Len()+len(line)+1 > statsdMaxPacket {
+			c.conn.Write(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	requests, errors := atomic.LoadUint64(&c.live.requests), atomic.LoadUint64(&c.live.errors)
+	send(fmt.Sprintf("%s.requests:%d|c", c.prefix, requests-c.requests))
+	send(fmt.Sprintf("%s.errors:%d|c", c.prefix, errors-c.errors))
+	send(fmt.Sprintf("%s.concurrency:%d|g", c.prefix, atomic.LoadInt64(&c.live.concurrency)))
+	c.requests, c.errors = requests, errors
+
+	for n := len(c.timings); n > 0; n-- {
+		latency := <-c.timings
+		line := fmt.Sprintf("%s.latency:%.3f|ms", c.prefix, latency.Seconds()*1000)
+		if c.sampleRate < 1 {
+			line += fmt.Sprintf("|@%g", c.sampleRate)
+		}
+		send(line)
+	}
+	if packet.Len() > 0 {
+		c.conn.Write(packet.Bytes())
+	}
+}
+
+// Sends anything not yet flushed and closes the connection.
+func (c *statsdClient) stop() {
+	close(c.done)
+	<-c.stopped
+	c.conn.Close()
+}
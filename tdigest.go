@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// The compression of a t-digest: roughly how many centroids it keeps, and
+// so how accurate it is.
+const tdigestCompression = 100
+
+// centroid is the mean of some nearby latencies, in seconds, and how many
+// there were.
+type centroid struct {
+	Mean   float64 `json:"mean"`
+	Weight float64 `json:"weight"`
+}
+
+// tdigest is a latencySketch after Ted Dunning's merging t-digest. It keeps
+// a few hundred centroids, smallest near the extremes so that tail
+// percentiles stay accurate, and digests can be merged without losing
+// accuracy, such as those of separate runs.
+type tdigest struct {
+	latencyStats
+	compression float64
+	centroids   []centroid
+	// Latencies not yet merged into centroids.
+	buffer []centroid
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{compression: compression}
+}
+
+// Maps quantile q onto the k1 scale, on which each centroid spans at most
+// 1.
+func (t *tdigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// Maps k back onto a quantile.
+func (t *tdigest) q(k float64) float64 {
+	if k >= t.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/t.compression) + 1) / 2
+}
+
+func (t *tdigest) record(d time.Duration) {
+	t.recordStats(d)
+	t.buffer = append(t.buffer, centroid{d.Seconds(), 1})
+	if len(t.buffer) >= 5*int(t.compression) {
+		t.compress()
+	}
+}
+
+func (t *tdigest) merge(o latencySketch) {
+	od := o.(*tdigest)
+	t.mergeStats(&od.latencyStats)
+	t.buffer = append(t.buffer, od.centroids...)
+	t.buffer = append(t.buffer, od.buffer...)
+	t.compress()
+}
+
+// Merges the buffer into the centroids, combining neighbours for as long as
+// they'd stay within a unit of the k scale.
+func (t *tdigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buffer...)
+	t.buffer = t.buffer[:0]
+	sort.Slice(all, func(i, j int) bool { return all[i].Mean < all[j].Mean })
+
+	var total float64
+	for _, c := range all {
+		total += c.Weight
+	}
+	merged := make([]centroid, 0, len(all))
+	cur := all[0]
+	var soFar float64
+	limit := t.q(t.k(0) + 1)
+	for _, c := range all[1:] {
+		if (soFar+cur.Weight+c.Weight)/total <= limit {
+			cur.Mean += (c.Mean - cur.Mean) * c.Weight / (cur.Weight + c.Weight)
+			cur.Weight += c.Weight
+			continue
+		}
+		merged = append(merged, cur)
+		soFar += cur.Weight
+		limit = t.q(t.k(soFar/total) + 1)
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+}
+
+// Returns the latency that percentile percent of those recorded are at or
+// below, interpolating between the centres of centroids and the exact
+// extremes, or 0 if none have been recorded.
+func (t *tdigest) valueAtPercentile(percent float64) time.Duration {
+	if t.n == 0 {
+		return 0
+	}
+	t.compress()
+	target := percent / 100 * float64(t.n)
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+
+	// Each centroid is taken to sit at the middle of the weight it covers.
+	prevCenter, prevMean := 0.0, t.minValue.Seconds()
+	var soFar float64
+	for _, c := range t.centroids {
+		center := soFar + c.Weight/2
+		if target < center {
+			if center == prevCenter {
+				return seconds(c.Mean)
+			}
+			return seconds(prevMean + (c.Mean-prevMean)*(target-prevCenter)/(center-prevCenter))
+		}
+		prevCenter, prevMean = center, c.Mean
+		soFar += c.Weight
+	}
+	if soFar == prevCenter {
+		return t.maxValue
+	}
+	maxValue := t.maxValue.Seconds()
+	return seconds(prevMean + (maxValue-prevMean)*math.Min((target-prevCenter)/(soFar-prevCenter), 1))
+}
+
+// Returns the centroids the digest has merged its latencies into, so that
+// it can be saved and merged with others later.
+func (t *tdigest) digest() []centroid {
+	t.compress()
+	return t.centroids
+}
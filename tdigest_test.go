@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Returns the exact latency at percentile percent of sorted.
+func exactPercentile(sorted []time.Duration, percent float64) time.Duration {
+	i := int(math.Ceil(percent/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func TestTDigestValueAtPercentile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range []struct {
+		name string
+		draw func() time.Duration
+	}{
+		{"constant", func() time.Duration { return 5 * time.Millisecond }},
+		{"uniform", func() time.Duration { return time.Duration(rng.Int63n(int64(100 * time.Millisecond))) }},
+		{"exponential", func() time.Duration { return time.Duration(rng.ExpFloat64() * float64(10*time.Millisecond)) }},
+		{"bimodal", func() time.Duration {
+			if rng.Float64() < 0.9 {
+				return time.Duration(rng.NormFloat64()*float64(100*time.Microsecond)) + time.Millisecond
+			}
+			return time.Duration(rng.NormFloat64()*float64(time.Millisecond)) + 50*time.Millisecond
+		}},
+	} {
+		d := newTDigest(tdigestCompression)
+		var all []time.Duration
+		for i := 0; i < 20000; i++ {
+			l := tc.draw()
+			d.record(l)
+			all = append(all, l)
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+		if d.count() != int64(len(all)) || d.min() != all[0] || d.max() != all[len(all)-1] {
+			t.Errorf("%s: count %d, min %s, max %s; want %d, %s, %s", tc.name, d.count(), d.min(), d.max(), len(all), all[0], all[len(all)-1])
+		}
+		for _, percent := range []float64{1, 50, 90, 99, 99.9} {
+			got := d.valueAtPercentile(percent)
+			// Compare ranks rather than values, which is what a t-digest
+			// bounds: the share of latencies at or below the estimate
+			// should be close to percent, closer still in the tails.
+			rank := 100 * float64(sort.Search(len(all), func(i int) bool { return all[i] > got })) / float64(len(all))
+			tolerance := 1.0
+			if percent < 10 || percent > 90 {
+				tolerance = 0.5
+			}
+			if want := exactPercentile(all, percent); got != want && math.Abs(rank-percent) > tolerance {
+				t.Errorf("%s: p%g is %s, at rank %.2f; the exact value is %s", tc.name, percent, got, rank, want)
+			}
+		}
+	}
+}
+
+func TestTDigestMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	whole := newTDigest(tdigestCompression)
+	parts := []*tdigest{newTDigest(tdigestCompression), newTDigest(tdigestCompression), newTDigest(tdigestCompression)}
+	for i := 0; i < 30000; i++ {
+		l := time.Duration(rng.ExpFloat64() * float64(time.Millisecond))
+		whole.record(l)
+		parts[i%len(parts)].record(l)
+	}
+	merged := newTDigest(tdigestCompression)
+	for _, p := range parts {
+		merged.merge(p)
+	}
+
+	if merged.count() != whole.count() || merged.min() != whole.min() || merged.max() != whole.max() {
+		t.Errorf("merged count %d, min %s, max %s; want %d, %s, %s", merged.count(), merged.min(), merged.max(), whole.count(), whole.min(), whole.max())
+	}
+	if n := len(merged.digest()); n > 2*tdigestCompression {
+		t.Errorf("merged digest has %d centroids, want at most %d", n, 2*tdigestCompression)
+	}
+	for _, percent := range []float64{50, 90, 99, 99.9} {
+		got, want := merged.valueAtPercentile(percent), whole.valueAtPercentile(percent)
+		if math.Abs(float64(got-want)) > 0.02*float64(want) {
+			t.Errorf("p%g of the merged parts is %s, want %s as for the whole", percent, got, want)
+		}
+	}
+}
+
+func TestTDigestEmpty(t *testing.T) {
+	d := newTDigest(tdigestCompression)
+	if got := d.valueAtPercentile(50); got != 0 {
+		t.Errorf("p50 of an empty digest is %s, want 0", got)
+	}
+}
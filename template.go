@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateFuncs returns the variables a request template may use. uuid and
+// randint draw from rng, while seq counts up from the shared counter so that
+// it's unique across workers.
+func templateFuncs(rng *rand.Rand, seq *uint64) template.FuncMap {
+	return template.FuncMap{
+		"uuid": func() string {
+			var b [16]byte
+			rng.Read(b[:])
+			b[6] = b[6]&0x0f | 0x40 // version 4
+			b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		},
+		"seq": func() uint64 {
+			return atomic.AddUint64(seq, 1)
+		},
+		"randint": func(min, max int) int {
+			if max < min {
+				min, max = max, min
+			}
+			return min + rng.Intn(max-min+1)
+		},
+		"pathEscape": func(v interface{}) string {
+			return url.PathEscape(fmt.Sprint(v))
+		},
+		"queryEscape": func(v interface{}) string {
+			return url.QueryEscape(fmt.Sprint(v))
+		},
+	}
+}
+
+// Rewrites the actions in list, and those in any block it contains, to pass
+// their output through the template function named escape, and the text
+// between them through escapeText, as html/template does for HTML.
+// Actions that only declare variables print nothing and are left alone.
+func escapeTemplate(list *parse.ListNode, escape string, escapeText func(string) string) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			n.Text = []byte(escapeText(string(n.Text)))
+		case *parse.ActionNode:
+			if len(n.Pipe.Decl) == 0 {
+				n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+					NodeType: parse.NodeCommand,
+					Pos:      n.Pos,
+					Args:     []parse.Node{parse.NewIdentifier(escape).SetPos(n.Pos)},
+				})
+			}
+		case *parse.IfNode:
+			escapeTemplate(n.List, escape, escapeText)
+			escapeTemplate(n.ElseList, escape, escapeText)
+		case *parse.RangeNode:
+			escapeTemplate(n.List, escape, escapeText)
+			escapeTemplate(n.ElseList, escape, escapeText)
+		case *parse.WithNode:
+			escapeTemplate(n.List, escape, escapeText)
+			escapeTemplate(n.ElseList, escape, escapeText)
+		}
+	}
+}
+
+// Returns the escaped form of a URL's path, which is kept decoded in
+// url.URL.Path.
+func escapePathText(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+// Checks that s is a valid request template by expanding it against data,
+// so mistakes are reported before any load is generated.
+func checkTemplate(s string, data map[string]string) error {
+	var seq uint64
+	e := newExpander(rand.New(rand.NewSource(0)), &seq, nil)
+	e.data = data
+	_, err := e.expand(s, "")
+	return err
+}
+
+// dataFeed holds the rows of a CSV file, keyed by column name, which are
+// handed out in turn to the requests of every worker.
+type dataFeed struct {
+	rows []map[string]string
+	next uint64
+}
+
+// Returns the next row, wrapping around once every row has been used.
+func (f *dataFeed) row() map[string]string {
+	i := atomic.AddUint64(&f.next, 1) - 1
+	return f.rows[i%uint64(len(f.rows))]
+}
+
+// expander expands the request templates of a single worker, caching each
+// template once it's been parsed.
+type expander struct {
+	funcs     template.FuncMap
+	templates map[templateKey]*template.Template
+	buf       bytes.Buffer
+	feed      *dataFeed
+	// The data feed row the current request's templates are expanded
+	// against.
+	data map[string]string
+}
+
+func newExpander(rng *rand.Rand, seq *uint64, feed *dataFeed) *expander {
+	return &expander{funcs: templateFuncs(rng, seq), templates: make(map[templateKey]*template.Template), feed: feed}
+}
+
+// templateKey identifies a parsed template by its text and how its output
+// is escaped, as the same text may be expanded in more than one part of a
+// request.
+type templateKey struct {
+	text, escape string
+}
+
+// Moves on to the next row of the data feed, if there is one.
+func (e *expander) advance() {
+	if e.feed != nil {
+		e.data = e.feed.row()
+	}
+}
+
+// Expands s, returning it unchanged if it has nothing to expand. With
+// escape set to pathEscape or queryEscape, what each action prints is
+// escaped with it, so that a value can't change the shape of the URL it's
+// spliced into, and s, the decoded path with pathEscape, is escaped too.
+func (e *expander) expand(s, escape string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		if escape == "pathEscape" {
+			return escapePathText(s), nil
+		}
+		return s, nil
+	}
+	key := templateKey{s, escape}
+	t, ok := e.templates[key]
+	if !ok {
+		var err error
+		t, err = template.New("").Funcs(e.funcs).Option("missingkey=error").Parse(s)
+		if err != nil {
+			return "", err
+		}
+		switch escape {
+		case "pathEscape":
+			escapeTemplate(t.Tree.Root, escape, escapePathText)
+		case "queryEscape":
+			escapeTemplate(t.Tree.Root, escape, func(s string) string { return s })
+		}
+		e.templates[key] = t
+	}
+	e.buf.Reset()
+	if err := t.Execute(&e.buf, e.data); err != nil {
+		return "", err
+	}
+	return e.buf.String(), nil
+}
+
+// Returns a copy of t with its URL, headers and body expanded. Values
+// expanded in the URL's path and query are escaped for them, while those in
+// the headers and body are left as they are.
+func (e *expander) expandRequest(t *requestType) (requestType, error) {
+	expanded := *t
+	u := *t.url
+	var err error
+	if u.RawPath, err = e.expand(u.Path, "pathEscape"); err != nil {
+		return expanded, err
+	}
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return expanded, err
+	}
+	if u.RawQuery, err = e.expand(u.RawQuery, "queryEscape"); err != nil {
+		return expanded, err
+	}
+	expanded.url = &u
+
+	expanded.header = make(http.Header, len(t.header))
+	for name, values := range t.header {
+		for _, value := range values {
+			if value, err = e.expand(value, ""); err != nil {
+				return expanded, err
+			}
+			expanded.header.Add(name, value)
+		}
+	}
+
+	if bytes.Contains(t.body, []byte("{{")) {
+		body, err := e.expand(string(t.body), "")
+		if err != nil {
+			return expanded, err
+		}
+		expanded.body = []byte(body)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandRequestEscapes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		url      string
+		value    string
+		wantURL  string
+		wantPath string
+	}{
+		{"plain", "http://h/users/{{.v}}?q={{.v}}", "alice", "http://h/users/alice?q=alice", "/users/alice"},
+		{"query delimiters", "http://h/search?q={{.v}}&page=1", "a&b=c d", "http://h/search?q=a%26b%3Dc+d&page=1", "/search"},
+		{"path separator", "http://h/files/{{.v}}/meta", "a/b?c", "http://h/files/a%2Fb%3Fc/meta", "/files/a/b?c/meta"},
+		{"escaped literal", "http://h/a%20b/{{.v}}", "c d", "http://h/a%20b/c%20d", "/a b/c d"},
+		{"pipeline", `http://h/{{print .v "/" .v}}`, "x", "http://h/x%2Fx", "/x/x"},
+		{"conditional", "http://h/{{if .v}}{{.v}}{{else}}none{{end}}", "#1", "http://h/%231", "/#1"},
+		{"variable", "http://h/{{$v := .v}}{{$v}}", "a b", "http://h/a%20b", "/a b"},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := http.Header{"X-Value": {"{{.v}}"}}
+		body := []byte(`{"v": "{{.v}}"}`)
+		var seq uint64
+		e := newExpander(rand.New(rand.NewSource(1)), &seq, nil)
+		e.data = map[string]string{"v": tc.value}
+		expanded, err := e.expandRequest(&requestType{url: u, header: header, body: body})
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if got := expanded.url.String(); got != tc.wantURL {
+			t.Errorf("%s: got URL %s, want %s", tc.name, got, tc.wantURL)
+		}
+		if expanded.url.Path != tc.wantPath {
+			t.Errorf("%s: got path %q, want %q", tc.name, expanded.url.Path, tc.wantPath)
+		}
+		if got := expanded.header.Get("X-Value"); got != tc.value {
+			t.Errorf("%s: got header %q, want %q unescaped", tc.name, got, tc.value)
+		}
+		if got, want := string(expanded.body), `{"v": "`+tc.value+`"}`; got != want {
+			t.Errorf("%s: got body %s, want %s unescaped", tc.name, got, want)
+		}
+	}
+}
+
+func TestExpandRequestFunctions(t *testing.T) {
+	u, _ := url.Parse("http://h/{{seq}}?n={{randint 5 5}}")
+	var seq uint64
+	e := newExpander(rand.New(rand.NewSource(1)), &seq, nil)
+	for _, want := range []string{"http://h/1?n=5", "http://h/2?n=5"} {
+		expanded, err := e.expandRequest(&requestType{url: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := expanded.url.String(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestDataFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "users.csv")
+	if err := ioutil.WriteFile(filename, []byte("user,token\nalice,a1\nbob,b2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := readDataFeed(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two workers share the feed, taking its rows in turn and wrapping
+	// around once they've all been used.
+	u, _ := url.Parse("http://h/users/{{.user}}")
+	request := &requestType{url: u, header: http.Header{"Authorization": {"Bearer {{.token}}"}}}
+	var seq uint64
+	workers := []*expander{
+		newExpander(rand.New(rand.NewSource(1)), &seq, feed),
+		newExpander(rand.New(rand.NewSource(2)), &seq, feed),
+	}
+	for i, want := range []struct{ path, auth string }{
+		{"/users/alice", "Bearer a1"},
+		{"/users/bob", "Bearer b2"},
+		{"/users/alice", "Bearer a1"},
+	} {
+		e := workers[i%len(workers)]
+		e.advance()
+		expanded, err := e.expandRequest(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expanded.url.Path != want.path || expanded.header.Get("Authorization") != want.auth {
+			t.Errorf("request %d: got %s with %q, want %s with %q", i, expanded.url.Path, expanded.header.Get("Authorization"), want.path, want.auth)
+		}
+	}
+
+	if err := checkTemplate("{{.missing}}", feed.rows[0]); err == nil {
+		t.Error("got no error for a column the feed doesn't have")
+	}
+	if err := ioutil.WriteFile(filename, []byte("user,token\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDataFeed(filename); err == nil {
+		t.Error("got no error for a feed without rows")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"text/tabwriter"
+	"time"
+
+	"gonum.org/v1/gonum/optimize"
+)
+
+// uslModel holds the coefficients of the Universal Scalability Law,
+// X(N) = lambda * N / (1 + sigma*(N-1) + kappa*N*(N-1)).
+type uslModel struct {
+	Sigma  float64 `json:"sigma"`
+	Kappa  float64 `json:"kappa"`
+	Lambda float64 `json:"lambda"`
+}
+
+// Returns the throughput the model predicts at concurrency n.
+func (m uslModel) throughputAt(n float64) float64 {
+	return concurrencyToThroughput(n, m.Sigma, m.Kappa, m.Lambda)
+}
+
+// Returns the mean latency, in seconds, that the model predicts at
+// concurrency n, by Little's law: n requests are in flight at any time,
+// each for n/X(n). It's NaN if the model predicts no throughput there, or
+// the latency is too long to be a time.Duration.
+func (m uslModel) latencyAt(n float64) float64 {
+	l := n / m.throughputAt(n)
+	if math.IsNaN(l) || l < 0 || l > time.Duration(math.MaxInt64).Seconds() {
+		return math.NaN()
+	}
+	return l
+}
+
+// Formats a latency in seconds from latencyAt as a duration.
+func formatLatency(seconds float64) string {
+	if math.IsNaN(seconds) {
+		return "unknown"
+	}
+	return time.Duration(seconds * float64(time.Second)).String()
+}
+
+// Returns the concurrency at which throughput peaks, which needn't be a
+// whole number.
+func (m uslModel) optimalConcurrency() float64 {
+	return math.Sqrt((1 - m.Sigma) / m.Kappa)
+}
+
+// Returns the concurrency level at which throughput peaks: whichever of the
+// whole numbers either side of optimalConcurrency gives more.
+func (m uslModel) maxConcurrency() float64 {
+	n := m.optimalConcurrency()
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return n
+	}
+	floor, ceil := math.Floor(n), math.Ceil(n)
+	if floor < 1 || m.throughputAt(ceil) > m.throughputAt(floor) {
+		return ceil
+	}
+	return floor
+}
+
+// Returns the throughput at maxConcurrency.
+func (m uslModel) maxRps() float64 {
+	return throughputAtConcurrency(m.maxConcurrency(), m.Kappa, m.Lambda, m.Sigma)
+}
+
+// Writes m and how well it fits as key=value lines, one per parameter, for
+// scripts to read.
+func printModelValues(w io.Writer, m uslModel, fit goodnessOfFit) {
+	fmt.Fprintf(w, "sigma=%g\n", m.Sigma)
+	fmt.Fprintf(w, "kappa=%g\n", m.Kappa)
+	fmt.Fprintf(w, "lambda=%g\n", m.Lambda)
+	fmt.Fprintf(w, "maxConcurrency=%g\n", m.maxConcurrency())
+	fmt.Fprintf(w, "maxRps=%g\n", m.maxRps())
+	fmt.Fprintf(w, "meanLatencyAtMaxConcurrency=%g\n", m.latencyAt(m.maxConcurrency()))
+	fmt.Fprintf(w, "rSquared=%g\n", fit.rSquared)
+	fmt.Fprintf(w, "rmse=%g\n", fit.rmse)
+}
+
+// Prints the coefficients of the model and the maxima they imply.
+func printModel(w io.Writer, m uslModel) {
+	fmt.Fprintln(w, "sigma (the overhead of contention): ", m.Sigma)
+	fmt.Fprintln(w, "kappa (the overhead of crosstalk): ", m.Kappa)
+	fmt.Fprintln(w, "lambda (unloaded performance): ", m.Lambda)
+	fmt.Fprintf(w, "maxConcurrency: %f\n", m.maxConcurrency())
+	fmt.Fprintf(w, "maxRps: %f\n", m.maxRps())
+	fmt.Fprintf(w, "mean latency at maxConcurrency: %s\n", formatLatency(m.latencyAt(m.maxConcurrency())))
+}
+
+// Prints the throughput the model predicts at each concurrency level and
+// the mean latency that implies.
+func printPredictions(w io.Writer, m uslModel, levels []int) {
+	for _, level := range levels {
+		fmt.Fprintf(w, "throughput at %d: %f, latency %s\n", level, m.throughputAt(float64(level)), formatLatency(m.latencyAt(float64(level))))
+	}
+}
+
+// How many points fitWeightedUSL starts the optimizer from.
+const fitStarts = 8
+
+// fitUSL finds the model that best fits the throughput measured at each
+// concurrency level, using least squares. The model is returned alongside
+// any optimization error so that callers can still report a best effort.
+func fitUSL(concurrency, throughput []float64) (uslModel, error) {
+	return fitWeightedUSL(concurrency, throughput, nil)
+}
+
+// fitFunc fits the model to measurements with the given weights, which may
+// be nil, such as fitWeightedUSL or fitRobustUSL.
+type fitFunc func(concurrency, throughput, weights []float64) (uslModel, error)
+
+// fitWeightedUSL is fitUSL with each squared residual multiplied by the
+// measurement's weight, or by 1 if weights is nil.
+func fitWeightedUSL(concurrency, throughput, weights []float64) (uslModel, error) {
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+
+	// `f` and `grad` were borrowed from https://play.golang.org/p/wWUH4E5LhP
+	f := func(x []float64) float64 {
+		sigma, kappa, lambda := optvarsToGreek(x)
+		var mismatch float64
+		for i, N := range concurrency {
+			pred := concurrencyToThroughput(N, sigma, kappa, lambda)
+			truth := throughput[i]
+			mismatch += weight(i) * (pred - truth) * (pred - truth)
+		}
+		return mismatch
+	}
+
+	grad := func(grad, x []float64) {
+		for i := range grad {
+			grad[i] = 0
+		}
+		sigma, kappa, lambda := optvarsToGreek(x)
+		dSigmaDX, dKappaDX, dLambdaDX := optvarsToGreekDeriv(x)
+		for i, N := range concurrency {
+			pred := concurrencyToThroughput(N, sigma, kappa, lambda)
+			truth := throughput[i]
+
+			dMismatchDPred := 2 * weight(i) * (pred - truth)
+			dPredDSigma, dPredDKappa, dPredDLambda := concurrencyToThroughputDeriv(N, sigma, kappa, lambda)
+
+			grad[0] += dMismatchDPred * dPredDSigma * dSigmaDX
+			grad[1] += dMismatchDPred * dPredDKappa * dKappaDX
+			grad[2] += dMismatchDPred * dPredDLambda * dLambdaDX
+		}
+	}
+
+	problem := optimize.Problem{
+		Func: f,
+		Grad: grad,
+	}
+	settings := optimize.DefaultSettings()
+	settings.GradientThreshold = 1e-2 // Looser tolerance because using FD derivative
+
+	// The optimizer can settle in a local minimum, so it's started from
+	// fitStarts points and the best result kept, preferring those where it
+	// converged. The first point is the one it always used to start from;
+	// the rest are random, but the same every time, so fits are repeatable.
+	rng := rand.New(rand.NewSource(1))
+	var (
+		best    *optimize.Result
+		bestErr error
+	)
+	for i := 0; i < fitStarts; i++ {
+		initX := []float64{0, -1, -3} // make sure they all start positive
+		if i > 0 {
+			initX = []float64{-8 * rng.Float64(), -12 * rng.Float64(), math.Log(throughput[0]/concurrency[0]) + 4*rng.Float64() - 2}
+		}
+		result, err := optimize.Local(problem, initX, nil, nil)
+		if result == nil || math.IsNaN(result.F) {
+			if best == nil {
+				bestErr = err
+			}
+			continue
+		}
+		if best == nil || (err == nil && bestErr != nil) || ((err == nil) == (bestErr == nil) && result.F < best.F) {
+			best, bestErr = result, err
+		}
+	}
+	if best == nil {
+		return uslModel{}, bestErr
+	}
+
+	sigma, kappa, lambda := optvarsToGreek(best.X)
+	return uslModel{Sigma: sigma, Kappa: kappa, Lambda: lambda}, bestErr
+}
+
+func throughputAtConcurrency(n, kappa, lambda, sigma float64) float64 {
+	return (lambda * n) / (1 + (sigma * (n - 1)) + (kappa * n * (n - 1)))
+}
+
+// These math functions were borrowed from https://play.golang.org/p/wWUH4E5LhP
+func optvarsToGreek(x []float64) (sigma, kappa, lambda float64) {
+	return math.Exp(x[0]), math.Exp(x[1]), math.Exp(x[2])
+}
+
+func optvarsToGreekDeriv(x []float64) (dSigmaDX, dKappaDX, dLambdaDX float64) {
+	return math.Exp(x[0]), math.Exp(x[1]), math.Exp(x[2])
+}
+
+func concurrencyToThroughput(concurrency, sigma, kappa, lambda float64) float64 {
+	N := concurrency
+	return lambda * N / (1 + sigma*(N-1) + kappa*N*(N-1))
+}
+
+func concurrencyToThroughputDeriv(concurrency, sigma, kappa, lambda float64) (dSigma, dKappa, dLambda float64) {
+	// X(N) = lambda * N / (1 + sigma*(N-1) + kappa*N*(N-1))
+	N := concurrency
+	num := lambda * N
+	denom := 1 + sigma*(N-1) + kappa*N*(N-1)
+	dSigma = -(num / (denom * denom)) * (N - 1)
+	dKappa = -(num / (denom * denom)) * (N - 1) * N
+	dLambda = N / denom
+	return dSigma, dKappa, dLambda
+}
+
+// goodnessOfFit describes how well a model fits the measurements: the
+// coefficient of determination, which is 1 for a perfect fit and 0 or less
+// for one no better than the mean, and the root mean squared error, in
+// requests per second.
+type goodnessOfFit struct {
+	rSquared, rmse float64
+}
+
+func newGoodnessOfFit(concurrency, throughput []float64, m uslModel) goodnessOfFit {
+	var mean float64
+	for _, x := range throughput {
+		mean += x
+	}
+	mean /= float64(len(throughput))
+
+	var ssr, sst float64
+	for i, N := range concurrency {
+		residual := throughput[i] - m.throughputAt(N)
+		ssr += residual * residual
+		sst += (throughput[i] - mean) * (throughput[i] - mean)
+	}
+	return goodnessOfFit{
+		rSquared: 1 - ssr/sst,
+		rmse:     math.Sqrt(ssr / float64(len(throughput))),
+	}
+}
+
+// The fewest measurements the model is fitted to when some are left out,
+// one more than it has coefficients, so that the fit isn't bound to be
+// exact.
+const minFitMeasurements = 4
+
+// The least R² at which a model the optimizer gave up on is still used.
+const minFailedFitRSquared = 0.5
+
+// Returns why the model, fitted with error err, can't be used, or "" if it
+// can: the optimizer failed to find a model that explains at least
+// minFailedFitRSquared of the variation, or the model has no finite peak,
+// as when sigma is at least 1.
+func fitFailure(m uslModel, err error, fit goodnessOfFit) string {
+	switch {
+	case err != nil && !(fit.rSquared >= minFailedFitRSquared):
+		return fmt.Sprintf("optimization error: %s", err)
+	case !(m.Lambda > 0) || math.IsInf(m.Lambda, 0) || math.IsNaN(m.Sigma) || math.IsNaN(m.Kappa):
+		return "the coefficients aren't physical"
+	case m.Sigma >= 1:
+		return fmt.Sprintf("sigma %g is at least 1, so throughput never rises above that of a single worker", m.Sigma)
+	}
+	if peak := m.maxConcurrency(); !(peak >= 1) || math.IsInf(peak, 0) {
+		return "the model has no peak"
+	}
+	if maxRps := m.maxRps(); !(maxRps > 0) || math.IsInf(maxRps, 0) {
+		return "the model has no peak"
+	}
+	return ""
+}
+
+// How many times the highest concurrency measured maxConcurrency can be,
+// or how many times smaller than the lowest, before the model is taken to
+// be extrapolating.
+const maxExtrapolation = 2
+
+// Returns why m, fitted to measurements at the given concurrency levels,
+// shouldn't be trusted, if it shouldn't: its peak is more than
+// maxExtrapolation times the highest level measured, or less than the
+// lowest divided by it, so that maxConcurrency and maxRps are
+// extrapolations. Models that aren't physical never get this far, as the
+// fit only finds positive sigma and kappa and fitFailure rejects the rest.
+func checkFit(m uslModel, concurrency []float64) (extrapolating string) {
+	lowest, highest := math.Inf(1), 0.0
+	for _, N := range concurrency {
+		lowest, highest = math.Min(lowest, N), math.Max(highest, N)
+	}
+	switch peak := m.maxConcurrency(); {
+	case peak > maxExtrapolation*highest:
+		extrapolating = fmt.Sprintf("maxConcurrency %.0f is far beyond the highest concurrency measured, %.0f; test higher concurrency levels", peak, highest)
+	case peak < lowest/maxExtrapolation:
+		extrapolating = fmt.Sprintf("maxConcurrency %.0f is far below the lowest concurrency measured, %.0f; test lower concurrency levels", peak, lowest)
+	}
+	return extrapolating
+}
+
+// unfittedModel stands in for a model that couldn't be fitted, so that
+// anything derived from it is NaN and reported as unknown rather than taken
+// from a failed fit.
+var unfittedModel = uslModel{Sigma: math.NaN(), Kappa: math.NaN(), Lambda: math.NaN()}
+
+// empiricalPeak is the highest throughput measured and the concurrency it
+// was measured at, reported instead of the model's maxima when it can't be
+// fitted.
+type empiricalPeak struct {
+	Concurrency float64 `json:"concurrency"`
+	Throughput  float64 `json:"throughput"`
+}
+
+func newEmpiricalPeak(concurrency, throughput []float64) empiricalPeak {
+	var p empiricalPeak
+	for i, x := range throughput {
+		if i == 0 || x > p.Throughput {
+			p = empiricalPeak{concurrency[i], x}
+		}
+	}
+	return p
+}
+
+// Prints the empirical peak, labeled as such, in place of the model.
+func printEmpiricalPeak(w io.Writer, p empiricalPeak) {
+	fmt.Fprintf(w, "maxConcurrency (empirical): %f\n", p.Concurrency)
+	fmt.Fprintf(w, "maxRps (empirical): %f\n", p.Throughput)
+}
+
+// Writes the empirical peak as key=value lines like printModelValues'.
+func printEmpiricalValues(w io.Writer, p empiricalPeak) {
+	fmt.Fprintf(w, "maxConcurrency=%g\n", p.Concurrency)
+	fmt.Fprintf(w, "maxRps=%g\n", p.Throughput)
+	fmt.Fprintln(w, "empirical=true")
+}
+
+// Prints a table of the signed residual (measured - predicted) and the
+// percentage error at each concurrency level, followed by the sum of squared
+// residuals, which is the value the fit minimized.
+func printResiduals(out io.Writer, concurrency, throughput []float64, m uslModel) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "concurrency\tmeasured\tpredicted\tresidual\terror %\t")
+	var ssr float64
+	for i, N := range concurrency {
+		pred := m.throughputAt(N)
+		residual := throughput[i] - pred
+		ssr += residual * residual
+		fmt.Fprintf(w, "%.0f\t%.0f\t%.2f\t%+.2f\t%+.2f\t\n", N, throughput[i], pred, residual, 100*residual/throughput[i])
+	}
+	w.Flush()
+	fmt.Fprintf(out, "sum of squared residuals: %f\n", ssr)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckFit(t *testing.T) {
+	// Peaks at a concurrency of 20.
+	m := uslModel{Sigma: 0.05, Kappa: 0.95 / 400, Lambda: 100}
+	for _, tc := range []struct {
+		levels []float64
+		want   string
+	}{
+		{[]float64{1, 2, 4, 8, 16, 32}, ""},
+		{[]float64{12, 16, 24}, ""},
+		{[]float64{10, 16}, ""},
+		{[]float64{40, 64}, ""},
+		{[]float64{1, 2, 4, 8}, "beyond the highest"},
+		{[]float64{50, 64, 100}, "below the lowest"},
+	} {
+		got := checkFit(m, tc.levels)
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("%v: got %q, want %q", tc.levels, got, tc.want)
+		}
+	}
+}
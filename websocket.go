@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The GUID that RFC 6455 appends to the client's key to compute the
+// server's Sec-WebSocket-Accept.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// The message each WebSocket worker sends and waits to have echoed back.
+var webSocketMessage = []byte("http-max-rps")
+
+// The largest frame readFrame accepts, far more than an echo of
+// webSocketMessage needs, so that a server can't make it allocate any
+// amount of memory.
+const maxWebSocketFrame = 1 << 20
+
+// wsConn is a minimal client side WebSocket connection, just enough to send
+// a message and read the reply.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Opens a WebSocket connection to u, which may use the ws, wss, http or
+// https schemes, connecting with dial. tlsConfig is used for wss and https.
+func dialWebSocket(u *url.URL, host string, dial func(network, addr string) (net.Conn, error), tlsConfig *tls.Config) (*wsConn, error) {
+	secure := u.Scheme == "wss" || u.Scheme == "https"
+	conn, err := dial("tcp", hostPort(u))
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if host != "" {
+		req.Host = host
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	response, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, httpError{fmt.Errorf("websocket handshake failed: %s", response.Status)}
+	}
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	if response.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, errors.New("websocket handshake failed: bad Sec-WebSocket-Accept")
+	}
+
+	return &wsConn{conn: conn, r: r}, nil
+}
+
+// Writes a single, final frame. Frames sent by a client must be masked.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	header[1] |= 0x80
+
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame := append(header, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Reads a single frame, returning whether it was the final fragment of a
+// message.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if n > maxWebSocketFrame {
+		err = fmt.Errorf("websocket frame of %d bytes is larger than the %d allowed", n, maxWebSocketFrame)
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// Sends message and waits for the server to reply with a complete message,
+// answering any pings along the way.
+func (c *wsConn) echo(message []byte) error {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.writeFrame(wsText, message); err != nil {
+		return err
+	}
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case wsText, wsBinary, wsContinuation:
+			if fin {
+				return nil
+			}
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return err
+			}
+		case wsClose:
+			return errors.New("websocket closed by server")
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, nil)
+	return c.conn.Close()
+}
+
+// webSocketWorker holds the connection a single load test worker sends
+// its messages over, dialing a new one whenever the last one failed.
+type webSocketWorker struct {
+	url       *url.URL
+	host      string
+	dial      func(network, addr string) (net.Conn, error)
+	tlsConfig *tls.Config
+	conn      *wsConn
+}
+
+// Sends a message and waits for the echo.
+func (w *webSocketWorker) echo() error {
+	if w.conn == nil {
+		conn, err := dialWebSocket(w.url, w.host, w.dial, w.tlsConfig)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	err := w.conn.echo(webSocketMessage)
+	if err != nil {
+		w.close()
+	}
+	return err
+}
+
+func (w *webSocketWorker) close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Accepts WebSocket connections and echoes every message sent over them.
+func echoWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + webSocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	rw.Flush()
+	// Servers shouldn't mask their frames, but readFrame doesn't mind.
+	c := &wsConn{conn: conn, r: bufio.NewReader(rw)}
+	for {
+		_, opcode, payload, err := c.readFrame()
+		if err != nil || opcode == wsClose {
+			return
+		}
+		if err := c.writeFrame(opcode, payload); err != nil {
+			return
+		}
+	}
+}
+
+func TestRunLoadTestsWebSocket(t *testing.T) {
+	const messages = 20
+	for _, tc := range []struct {
+		name      string
+		newServer func(http.Handler) *httptest.Server
+		scheme    string
+	}{
+		{"ws", httptest.NewServer, "ws"},
+		{"wss", httptest.NewTLSServer, "wss"},
+	} {
+		server := tc.newServer(http.HandlerFunc(echoWebSocket))
+		u, _ := url.Parse(server.URL)
+		u.Scheme = tc.scheme
+		newSketch, _ := latencySketchMaker("hdr")
+		opts := &loadTestOptions{
+			types:            []requestType{{name: "websocket", url: u, weight: 1}},
+			websocket:        true,
+			timePerLevel:     time.Second,
+			newLatencySketch: newSketch,
+			requestsPerLevel: messages,
+			transport:        transportOptions{insecure: true},
+		}
+		// A transport that leaves dialing and TLS to net/http's defaults,
+		// as one injected by a test might.
+		r := runLoadTests(opts, 2, &http.Transport{})
+		if r.requests != messages || r.errors != 0 {
+			t.Errorf("%s: got %d echoed and %d errors, want %d and 0", tc.name, r.requests, r.errors, messages)
+		}
+		server.Close()
+	}
+}
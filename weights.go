@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// Returns the weight of each measurement in the fit for -weights: nil for
+// "none", the inverse of the variance of the throughput measured at the same
+// concurrency for "variance", and the inverse of the throughput for
+// "throughput". Either way they're scaled to average 1. Weighting by
+// variance needs every level to have been measured more than once, such as
+// with -replicates.
+func fitWeights(kind string, concurrency, throughput []float64) ([]float64, error) {
+	weights := make([]float64, len(concurrency))
+	switch kind {
+	case "none":
+		return nil, nil
+	case "variance":
+		byLevel := make(map[float64][]float64)
+		for i, N := range concurrency {
+			byLevel[N] = append(byLevel[N], throughput[i])
+		}
+		for i, N := range concurrency {
+			xs := byLevel[N]
+			if len(xs) < 2 {
+				return nil, fmt.Errorf("concurrency %.0f was only measured once", N)
+			}
+			v := stat.Variance(xs, nil)
+			if v == 0 {
+				return nil, fmt.Errorf("throughput at concurrency %.0f didn't vary", N)
+			}
+			weights[i] = 1 / v
+		}
+	case "throughput":
+		for i, x := range throughput {
+			if x <= 0 {
+				return nil, fmt.Errorf("throughput at concurrency %.0f was %.0f", concurrency[i], x)
+			}
+			weights[i] = 1 / x
+		}
+	default:
+		return nil, fmt.Errorf("unknown weighting: %s", kind)
+	}
+
+	mean := stat.Mean(weights, nil)
+	for i := range weights {
+		weights[i] /= mean
+	}
+	return weights, nil
+}